@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fileshare/internal/audit"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runAudit implements "fileshare audit verify [-file audit.log]".
+func runAudit(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: fileshare audit verify [-file path]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	file := fs.String("file", "audit.log", "Audit log to verify")
+	fs.Parse(args[1:])
+
+	n, err := audit.Verify(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED after %d valid records: %v\n", n, err)
+		return 1
+	}
+	fmt.Printf("OK: %d records, chain intact\n", n)
+	return 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fileshare/internal/network"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runDiscover implements "fileshare discover [-timeout 2s] [-json] [name]".
+// Given a name it prints just that server's URL, for use in scripts and
+// with push.
+func runDiscover(args []string) int {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 2*time.Second, "How long to listen for servers")
+	asJSON := fs.Bool("json", false, "Print the servers as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: fileshare discover [-timeout 2s] [-json] [name]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx := context.Background()
+	if name := fs.Arg(0); name != "" {
+		s, err := network.FindServer(ctx, name, *timeout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *asJSON {
+			return printJSON(s)
+		}
+		fmt.Println(s.URL())
+		return 0
+	}
+
+	servers, err := network.Discover(ctx, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Discovery failed:", err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(servers)
+	}
+	if len(servers) == 0 {
+		fmt.Fprintln(os.Stderr, "No fileshare servers found")
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tURL\tHOST\tVERSION\tUPLOAD\tADMIN\tFINGERPRINT")
+	for _, s := range servers {
+		fp := s.Fingerprint()
+		if len(fp) > 16 {
+			fp = fp[:16] + "…"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Instance, s.URL(), s.Host, s.TXT["version"],
+			yesNo(s.TXT["upload"]), yesNo(s.TXT["admin"]), fp)
+	}
+	w.Flush()
+	return 0
+}
+
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func yesNo(flag string) string {
+	if flag == "1" {
+		return "yes"
+	}
+	return "no"
+}
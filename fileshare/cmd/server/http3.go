@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server serves handler over QUIC on the UDP port with the same
+// number as the TCP listener, so one firewall rule pair covers both.
+func newHTTP3Server(port string, handler http.Handler) *http3.Server {
+	return &http3.Server{
+		Addr:           ":" + port,
+		Handler:        handler,
+		MaxHeaderBytes: 1 << 20,
+		IdleTimeout:    120 * time.Second,
+	}
+}
+
+// advertiseHTTP3 adds an Alt-Svc header to responses sent directly over
+// TLS, telling browsers they can switch to HTTP/3 for later requests.
+// Responses relayed by a proxy are left alone since the client cannot
+// reach this port.
+func advertiseHTTP3(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && r.ProtoMajor < 3 {
+			// Fails only until the UDP listener is up.
+			h3.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
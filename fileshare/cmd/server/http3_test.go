@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fileshare/internal/handlers"
+	"fileshare/internal/storage"
+	"fileshare/internal/worker"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const (
+	benchChunkSize = 1 << 20
+	benchRTT       = 10 * time.Millisecond
+	benchStreams   = 4
+	benchMTU       = 1400
+)
+
+// BenchmarkLossyUpload uploads 1 MiB chunks over one connection with
+// several streams at once, the way the browser uploader does, on a link
+// that delays everything the client sends by benchRTT and loses a share of
+// its packets. QUIC packets are really dropped and recovered by quic-go.
+// TCP cannot lose data above the kernel, so lossyConn models how Reno
+// would slow down instead.
+//
+//	go test ./cmd/server -run '^$' -bench LossyUpload -benchtime 50x
+func BenchmarkLossyUpload(b *testing.B) {
+	for _, loss := range []float64{0, 0.01, 0.05} {
+		for _, proto := range []string{"h2", "h3"} {
+			b.Run(fmt.Sprintf("loss=%g%%/%s", loss*100, proto), func(b *testing.B) {
+				link := &lossyLink{loss: loss, delay: benchRTT, rng: rand.New(rand.NewPCG(1, 2))}
+				srv := httptest.NewUnstartedServer(benchUploadHandler(b))
+				srv.EnableHTTP2 = true
+				srv.StartTLS()
+				defer srv.Close()
+				base := srv.Client().Transport.(*http.Transport)
+
+				var rt http.RoundTripper
+				url := srv.URL
+				if proto == "h2" {
+					tr := base.Clone()
+					tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+						c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+						if err != nil {
+							return nil, err
+						}
+						return newLossyConn(c, link), nil
+					}
+					defer tr.CloseIdleConnections()
+					rt = tr
+				} else {
+					tr, addr := startHTTP3(b, srv, link)
+					defer tr.Close()
+					rt, url = tr, "https://"+addr
+				}
+				benchmarkChunks(b, &http.Client{Transport: rt}, url)
+			})
+		}
+	}
+}
+
+func benchUploadHandler(b *testing.B) http.Handler {
+	pool := worker.NewPool(runtime.NumCPU(), 500)
+	pool.Start()
+	b.Cleanup(pool.Stop)
+	return handlers.ChunkedUploadHandler(storage.NewLocal(b.TempDir()), pool, nil, nil, nil, nil,
+		handlers.UploadOptions{ChunkSize: benchChunkSize})
+}
+
+// startHTTP3 serves srv's handler over QUIC with srv's certificate and
+// returns a transport whose packets go through link.
+func startHTTP3(b *testing.B, srv *httptest.Server, link *lossyLink) (*http3.Transport, string) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	h3 := &http3.Server{Handler: srv.Config.Handler, TLSConfig: http3.ConfigureTLSConfig(srv.TLS.Clone())}
+	go h3.Serve(pc)
+	b.Cleanup(func() { h3.Close(); pc.Close() })
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	qt := &quic.Transport{Conn: newLossyPacketConn(client, link)}
+	b.Cleanup(func() { qt.Close() })
+	tlsConf := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	return &http3.Transport{
+		TLSClientConfig: tlsConf,
+		Dial: func(ctx context.Context, _ string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			return qt.DialEarly(ctx, pc.LocalAddr(), tlsCfg, cfg)
+		},
+	}, pc.LocalAddr().String()
+}
+
+func benchmarkChunks(b *testing.B, client *http.Client, base string) {
+	chunk := bytes.Repeat([]byte{0xab}, benchChunkSize)
+	// Set up the connection before timing starts, so all streams share it.
+	resp, err := client.Get(base + "/upload")
+	if err != nil {
+		b.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var next atomic.Int64
+	b.SetBytes(benchChunkSize)
+	b.SetParallelism(max(1, benchStreams/runtime.GOMAXPROCS(0)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		name := fmt.Sprintf("stream-%d.bin", next.Add(1))
+		var offset int64
+		for pb.Next() {
+			for {
+				req, _ := http.NewRequest(http.MethodPost, base+"/upload?dir=/", bytes.NewReader(chunk))
+				req.Header.Set("X-File-Name", name)
+				req.Header.Set("X-Chunk-Offset", strconv.FormatInt(offset, 10))
+				resp, err := client.Do(req)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					break
+				}
+				if resp.StatusCode != http.StatusTooManyRequests {
+					b.Errorf("chunk at %d: %s", offset, resp.Status)
+					return
+				}
+			}
+			offset += benchChunkSize
+		}
+	})
+}
+
+// lossyLink decides which packets are lost and how long the rest take.
+type lossyLink struct {
+	loss  float64
+	delay time.Duration
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// lost reports whether a write of n bytes loses at least one packet.
+func (l *lossyLink) lost(n int) bool {
+	packets := float64(max(1, (n+benchMTU-1)/benchMTU))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rng.Float64() < 1-math.Pow(1-l.loss, packets)
+}
+
+// delayLine sends what is put into it after a delay, in order.
+type delayLine struct {
+	delay time.Duration
+	queue chan segment
+
+	mu     sync.Mutex
+	closed bool
+	last   time.Time
+}
+
+type segment struct {
+	due  time.Time
+	data []byte
+	addr net.Addr
+}
+
+func newDelayLine(delay time.Duration, send func(data []byte, addr net.Addr) error, done func()) *delayLine {
+	d := &delayLine{delay: delay, queue: make(chan segment, 1024)}
+	go func() {
+		defer done()
+		for s := range d.queue {
+			time.Sleep(time.Until(s.due))
+			if err := send(s.data, s.addr); err != nil {
+				return
+			}
+		}
+	}()
+	return d
+}
+
+// put queues p to arrive one delay after at. It never arrives before
+// anything put earlier.
+func (d *delayLine) put(p []byte, addr net.Addr, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return net.ErrClosed
+	}
+	due := at.Add(d.delay)
+	if due.Before(d.last) {
+		due = d.last
+	}
+	d.last = due
+	d.queue <- segment{due: due, data: bytes.Clone(p), addr: addr}
+	return nil
+}
+
+func (d *delayLine) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+}
+
+// lossyPacketConn delays the datagrams it sends and drops some of them.
+type lossyPacketConn struct {
+	net.PacketConn
+	link *lossyLink
+	line *delayLine
+}
+
+func newLossyPacketConn(c net.PacketConn, link *lossyLink) *lossyPacketConn {
+	send := func(data []byte, addr net.Addr) error {
+		_, err := c.WriteTo(data, addr)
+		return err
+	}
+	return &lossyPacketConn{PacketConn: c, link: link, line: newDelayLine(link.delay, send, func() {})}
+}
+
+func (c *lossyPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.link.lost(len(p)) {
+		return len(p), nil
+	}
+	return len(p), c.line.put(p, addr, time.Now())
+}
+
+func (c *lossyPacketConn) Close() error {
+	c.line.close()
+	return c.PacketConn.Close()
+}
+
+// lossyConn carries a TCP stream over the link. Data cannot be lost above
+// the kernel, so loss is modelled the way Reno reacts to it: at most a
+// congestion window is sent per round trip, the window doubles each round
+// until the first loss and then grows by a packet, and a loss halves it and
+// holds the stream back one round trip for the retransmit.
+type lossyConn struct {
+	net.Conn
+	link *lossyLink
+	line *delayLine
+
+	mu       sync.Mutex
+	round    time.Time // when the current round trip started sending
+	sent     int
+	cwnd     int
+	ssthresh int
+}
+
+func newLossyConn(c net.Conn, link *lossyLink) *lossyConn {
+	send := func(data []byte, _ net.Addr) error {
+		_, err := c.Write(data)
+		return err
+	}
+	return &lossyConn{
+		Conn:     c,
+		link:     link,
+		line:     newDelayLine(link.delay, send, func() { c.Close() }),
+		cwnd:     10 * benchMTU,
+		ssthresh: math.MaxInt,
+	}
+}
+
+func (c *lossyConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	delay := c.link.delay
+	if now := time.Now(); now.After(c.round.Add(delay)) {
+		c.round, c.sent = now, 0
+	}
+	if c.sent > 0 && c.sent+len(p) > c.cwnd {
+		c.round, c.sent = c.round.Add(delay), 0
+		if c.cwnd < c.ssthresh {
+			c.cwnd *= 2
+		} else {
+			c.cwnd += benchMTU
+		}
+	}
+	if c.link.lost(len(p)) {
+		c.ssthresh = max(c.cwnd/2, 2*benchMTU)
+		c.cwnd = c.ssthresh
+		c.round, c.sent = c.round.Add(delay), 0
+	}
+	c.sent += len(p)
+	at := c.round
+	c.mu.Unlock()
+
+	if err := c.line.put(p, nil, at); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close lets queued writes drain before the connection is closed.
+func (c *lossyConn) Close() error {
+	c.line.close()
+	return nil
+}
@@ -1,29 +1,58 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fileshare/internal/activity"
+	"fileshare/internal/audit"
 	"fileshare/internal/cleanup"
+	"fileshare/internal/config"
+	"fileshare/internal/dashboard"
 	"fileshare/internal/handlers"
+	"fileshare/internal/ignore"
+	"fileshare/internal/index"
+	"fileshare/internal/logging"
+	"fileshare/internal/metrics"
 	"fileshare/internal/network"
+	"fileshare/internal/proxy"
+	"fileshare/internal/push"
+	"fileshare/internal/qrcode"
+	"fileshare/internal/ratelimit"
+	"fileshare/internal/share"
+	"fileshare/internal/storage"
 	"fileshare/internal/templates"
 	"fileshare/internal/worker"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/mdp/qrterminal/v3"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/term"
 )
 
 const (
 	certFile = "cert.pem"
 	KeyFile  = "key.pem"
+
+	// pushOfferTTL is how long a push offer waits for an answer.
+	pushOfferTTL = 2 * time.Minute
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 func getBinaryDir() string {
     ex, err := os.Executable()
     if err != nil {
@@ -33,55 +62,439 @@ func getBinaryDir() string {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "audit":
+			os.Exit(runAudit(os.Args[2:]))
+		case "discover":
+			os.Exit(runDiscover(os.Args[2:]))
+		case "push":
+			os.Exit(runPush(os.Args[2:]))
+		}
+	}
+
+	startDir, _ := os.Getwd()
+	tuiPtr := flag.Bool("tui", false, "Show a live dashboard of clients and transfers instead of log output")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], startDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	binDir := getBinaryDir()
-	certPath := filepath.Join(binDir, certFile)
-	keyPath := filepath.Join(binDir, KeyFile)
-	portPtr := flag.String("port", "8080", "The port to run the server on")
-	flag.Parse()
-
-	numWorkers := runtime.NumCPU()
-	uploadPool := worker.NewPool(numWorkers, 500)
-	downloadPool := worker.NewPool(4, 20)
+	certPath := cmp.Or(cfg.TLS.Cert, filepath.Join(binDir, certFile))
+	keyPath := cmp.Or(cfg.TLS.Key, filepath.Join(binDir, KeyFile))
+
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format, os.Stderr); err != nil {
+		fatal("Invalid logging flags", "err", err)
+	}
+
+	useTLS := cfg.TLS.Mode == "on"
+	if cfg.TLS.Mode == "auto" {
+		useTLS = fileExists(certPath) && fileExists(keyPath)
+		if !useTLS {
+			slog.Warn("No TLS certificate found, serving plain HTTP", "cert", certPath, "key", keyPath)
+		}
+	}
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	trustedProxies, _ := proxy.ParseTrusted(cfg.TrustedProxies)
+	if !useTLS && trustedProxies == nil {
+		// A reverse proxy usually terminates TLS and connects from this
+		// machine; without -trusted-proxy every client then looks local.
+		slog.Warn("Serving plain HTTP without -trusted-proxy: behind a reverse proxy on this machine every client counts as local for /push",
+			"hint", "set -trusted-proxy 127.0.0.1")
+	}
+	if cfg.Root != "" {
+		if err := os.Chdir(cfg.Root); err != nil {
+			fatal("Could not change to root directory", "err", err)
+		}
+	}
+
+	var accessLog *logging.AccessLog
+	if cfg.Log.AccessFile != "" {
+		var err error
+		accessLog, err = logging.NewAccessLog(cfg.Log.AccessFile, cfg.Log.AccessFormat, cfg.Log.AccessMaxSizeMB<<20, cfg.Log.AccessBackups)
+		if err != nil {
+			fatal("Could not open access log", "err", err)
+		}
+		defer accessLog.Close()
+	}
+
+	limiter := ratelimit.New(limitsOf(cfg))
+	watchLimitSignal(limiter)
+
+	uploadPool := worker.NewPool(cfg.Pools.UploadWorkers, cfg.Pools.UploadQueue)
+	downloadPool := worker.NewPool(cfg.Pools.DownloadWorkers, cfg.Pools.DownloadQueue)
+	downloadPool.MaxPerClient = cfg.Pools.ZipsPerClient
+	downloadPool.JobTimeout = time.Duration(cfg.Pools.ZipTimeout)
 	uploadPool.Start()
 	downloadPool.Start()
-	defer uploadPool.Stop()
-	defer downloadPool.Stop()
+	metrics.RegisterPool("upload", uploadPool)
+	metrics.RegisterPool("download", downloadPool)
 
+	started := time.Now()
 	currentDir, _ := os.Getwd()
+	tracker := activity.New()
+
+	var auditLog *audit.Log
+	if cfg.Log.AuditFile != "" {
+		var err error
+		auditLog, err = audit.Open(cfg.Log.AuditFile)
+		if err != nil {
+			fatal("Could not open audit log", "err", err)
+		}
+		defer auditLog.Close()
+	}
 
-	cleanup.StartCleanupRoutine(currentDir, 24*time.Hour, 1*time.Hour)
+	store := storage.NewLocal(currentDir)
+	cleanupRoutine := cleanup.StartCleanupRoutine(store, time.Duration(cfg.Cleanup.MaxAge), time.Duration(cfg.Cleanup.Interval))
 
-	http.HandleFunc("/", handlers.FileServerHandler(currentDir))
-	http.HandleFunc("/upload", handlers.ChunkedUploadHandler())
-	http.HandleFunc("/zip", handlers.ZipHandlerFactory(downloadPool))
+	rl := &reloader{
+		args:         os.Args[1:],
+		baseDir:      startDir,
+		limiter:      limiter,
+		downloadPool: downloadPool,
+		cleanup:      cleanupRoutine,
+	}
+	rl.current.Store(cfg)
+	watchReloadSignal(rl.reload)
+
+	ignoreMatcher, err := ignore.New(currentDir, cfg.Ignore, cfg.ShowHidden)
+	if err != nil {
+		fatal("Invalid ignore rules", "err", err)
+	}
+	// Index snapshots, logs and the QR code image kept inside the share are
+	// not shared themselves.
+	ignoreMatcher.Hide(currentDir, cfg.Index.File, cfg.Index.ContentFile, cfg.Log.AuditFile, cfg.QR.File)
+	ignoreMatcher.HideRotated(currentDir, cfg.Log.AccessFile)
+	if cfg.QR.File != "" && cfg.QR.URL == "admin" && insideDir(currentDir, cfg.QR.File) {
+		// Hidden or not, an image of the admin password has no business
+		// in the folder everyone can browse.
+		fatal("Not writing the admin QR code inside the shared folder", "file", cfg.QR.File)
+	}
+
+	idx := index.New(currentDir, cfg.Index.File, ignoreMatcher)
+	if err := idx.Start(); err != nil {
+		slog.Warn("File index disabled", "err", err)
+	}
+	defer idx.Close()
+
+	var contentIdx *index.ContentIndex
+	if cfg.Index.ContentFile != "" {
+		contentIdx = index.NewContentIndex(idx, cfg.Index.ContentFile, int64(cfg.Index.ContentMaxSize))
+		contentIdx.Start()
+		defer contentIdx.Close()
+	}
+
+	// Closed on shutdown so long-lived event streams do not hold it up.
+	stopStreams := make(chan struct{})
+
+	// Share links are made on the admin page or for -qr-url share; with
+	// -links-only they are the only way in to the files.
+	links := share.New()
+	var requiredLinks *share.Links
+	if cfg.Admin.LinksOnly {
+		requiredLinks = links
+	}
+	gate := func(scope func(*http.Request) string, h http.HandlerFunc) http.HandlerFunc {
+		return handlers.RequireLink(requiredLinks, scope, h)
+	}
+	http.HandleFunc("/s/", metrics.Instrument("share", handlers.ShareLinkHandler(links)))
+	http.HandleFunc("/", metrics.Instrument("files", gate(handlers.ScopePath, handlers.FileServerHandler(store, idx, ignoreMatcher, auditLog, limiter))))
+	http.HandleFunc("/search", metrics.Instrument("search", gate(handlers.ScopeRoot, handlers.SearchHandler(idx, contentIdx))))
+	http.HandleFunc("/events", metrics.Instrument("events", gate(handlers.ScopeRoot, handlers.EventsHandler(idx, ignoreMatcher, stopStreams))))
+	http.HandleFunc("/recent", metrics.Instrument("recent", gate(handlers.ScopeRoot, handlers.RecentHandler(idx))))
+	uploadOpts := handlers.UploadOptions{
+		ChunkSize:      int64(cfg.Upload.ChunkSize),
+		ParallelChunks: cfg.Upload.ParallelChunks,
+	}
+	inbox := push.NewInbox(pushOfferTTL)
+	upload := handlers.ChunkedUploadHandler(store, uploadPool, ignoreMatcher, inbox, auditLog, limiter, uploadOpts)
+	linkedUpload := gate(handlers.ScopeQuery("dir"), upload)
+	http.HandleFunc("/upload", metrics.Instrument("upload", func(w http.ResponseWriter, r *http.Request) {
+		// A pushed chunk is vouched for by its accepted offer, which the
+		// handler checks, rather than by a share link.
+		if r.Header.Get(push.OfferHeader) != "" {
+			upload(w, r)
+			return
+		}
+		linkedUpload(w, r)
+	}))
+	http.HandleFunc("/push/offer", metrics.Instrument("push", handlers.PushOfferHandler(inbox, uploadOpts)))
+	http.HandleFunc("/zip", metrics.Instrument("zip", gate(handlers.ScopeQuery("path"), handlers.ZipHandlerFactory(store, downloadPool, idx, ignoreMatcher, auditLog, limiter))))
+	http.Handle("/metrics", metrics.Handler())
+	// With an admin password set, /admin and /audit are enabled.
+	protect := func(h http.HandlerFunc) http.HandlerFunc { return h }
+	if cfg.Admin.Password != "" {
+		protect = func(h http.HandlerFunc) http.HandlerFunc {
+			return handlers.RequireAdmin(cfg.Admin.User, cfg.Admin.Password, h)
+		}
+		admin := metrics.Instrument("admin", protect(handlers.AdminHandler(handlers.AdminConfig{
+			Root:         currentDir,
+			Started:      started,
+			UploadPool:   uploadPool,
+			DownloadPool: downloadPool,
+			Cleanup:      cleanupRoutine,
+			Limiter:      limiter,
+			AuditLog:     auditLog,
+			Links:        links,
+			LinksOnly:    cfg.Admin.LinksOnly,
+		})))
+		http.HandleFunc("/admin", admin)
+		http.HandleFunc("/admin/", admin)
+	}
+	// Offers are answered from this machine, or by the admin from anywhere.
+	var remoteInbox http.HandlerFunc
+	inboxHandler := handlers.PushInboxHandler(inbox)
+	if cfg.Admin.Password != "" {
+		remoteInbox = protect(inboxHandler)
+	}
+	pushInbox := metrics.Instrument("push", handlers.RequireLocal(inboxHandler, remoteInbox))
+	http.HandleFunc("/push", pushInbox)
+	http.HandleFunc("/push/answer", pushInbox)
+	if auditLog != nil && cfg.Admin.Password != "" {
+		http.HandleFunc("/audit", metrics.Instrument("audit", protect(handlers.AuditHandler(auditLog))))
+	} else if auditLog != nil {
+		slog.Warn("Audit viewer disabled, set an admin password to use /audit")
+	}
 
 	// Serve the embedded upload script
 	http.HandleFunc("/static/upload.js", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/javascript")
 		w.Write(templates.UploadScript)
 	})
+	http.HandleFunc("/static/live.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Write(templates.LiveScript)
+	})
 
-	ip, iface := network.GetLocalIP()
-	portInt, _ := strconv.Atoi(*portPtr)
-	server, err := network.StartMDNS(portInt, ip, iface)
-	if err == nil {
-		defer server.Shutdown()
+	localAddrs, err := network.LocalAddresses()
+	if err != nil {
+		slog.Warn("Could not list network interfaces", "err", err)
+	}
+	addrs, err := network.SelectAddresses(localAddrs, cfg.Interfaces)
+	if err != nil {
+		fatal("Invalid interface selection", "err", err)
+	}
+	portInt, _ := strconv.Atoi(cfg.Port)
+	announcement := network.Announcement{
+		Name:     cfg.MDNS.Name,
+		Instance: cfg.MDNS.Instance,
+		Port:     portInt,
+		Addrs:    addrs,
+		Version:  version,
+		TLS:      useTLS,
+		Admin:    cfg.Admin.Password != "",
+		Upload:   true,
+		Push:     true,
+	}
+	if useTLS {
+		announcement.CertFingerprint, err = certFingerprint(certPath)
+		if err != nil {
+			fatal("Could not read TLS certificate", "err", err)
+		}
+	}
+	mdnsServer, _ := network.StartMDNS(announcement)
+	domain := cfg.MDNS.Name
+	if mdnsServer != nil {
+		domain = mdnsServer.Name
 	}
 
-	fullURL := fmt.Sprintf("https://%s:%s", ip, *portPtr)
+	fullURL := fmt.Sprintf("%s://localhost:%s", scheme, cfg.Port)
+	if len(addrs) > 0 {
+		fullURL = addrs[0].URL(scheme, cfg.Port)
+	}
 	fmt.Printf("\n--- Server Running ---\n")
 	fmt.Printf("Sharing: %s\n", currentDir)
-	fmt.Printf("On domain: https://fileshare.local:%s\n", *portPtr)
+	fmt.Printf("On domain: %s://%s.local:%s\n", scheme, domain, cfg.Port)
 	fmt.Printf("URL: %s\n", fullURL)
+	for _, a := range addrs[min(1, len(addrs)):] {
+		fmt.Printf("Also at: %s (%s)\n", a.URL(scheme, cfg.Port), a.Interface.Name)
+	}
+	fmt.Printf("Incoming pushes: %s://localhost:%s/push\n", scheme, cfg.Port)
 
-	qrterminal.GenerateHalfBlock(fullURL, qrterminal.L, os.Stdout)
+	qrURL := fullURL
+	switch cfg.QR.URL {
+	case "mdns":
+		qrURL = fmt.Sprintf("%s://%s.local:%s", scheme, domain, cfg.Port)
+	case "admin":
+		u, _ := url.Parse(fullURL + "/admin")
+		u.User = url.UserPassword(cfg.Admin.User, cfg.Admin.Password)
+		qrURL = u.String()
+	case "share":
+		// Unlike the admin credentials, a share link can be revoked on the
+		// admin page once the code has been passed around.
+		link := links.Create("/", 0)
+		qrURL = fullURL + "/s/" + link.Token
+	}
+	if cfg.QR.Serve && cfg.QR.URL == "admin" && !useTLS {
+		slog.Warn("Not serving the admin QR code over plain HTTP", "path", "/qr")
+	} else if cfg.QR.Serve {
+		qrHandler := handlers.QRHandler(qrURL)
+		if cfg.QR.URL == "admin" || cfg.QR.URL == "share" {
+			// Only someone who already has the credentials may see them,
+			// even from this machine: a local proxy makes everyone local.
+			qrHandler = protect(qrHandler)
+		}
+		http.HandleFunc("/qr", metrics.Instrument("qr", qrHandler))
+		fmt.Printf("QR code: %s://localhost:%s/qr\n", scheme, cfg.Port)
+	}
+	if cfg.QR.File != "" {
+		if err := qrcode.WriteFile(cfg.QR.File, qrURL); err != nil {
+			slog.Warn("Could not write QR code", "file", cfg.QR.File, "err", err)
+		}
+	}
+	width := 0
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		width = w
+	}
+	if err := qrcode.Terminal(os.Stdout, qrURL, width, qrcode.Options{Invert: cfg.QR.Invert, Compact: cfg.QR.Compact}); err != nil {
+		fmt.Printf("(%v; try -qr-compact, -qr-file or the /qr page)\n", err)
+	}
 
-	http := &http.Server{
-		Addr:              ":" + *portPtr,
+	if !*tuiPtr {
+		promptOffers(inbox)
+	}
+
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	closeDashboard := make(chan struct{})
+	dashboardDone := make(chan struct{})
+	if *tuiPtr {
+		go func() {
+			defer close(dashboardDone)
+			runDashboard(&dashboard.Dashboard{
+				Tracker:      tracker,
+				DownloadPool: downloadPool,
+				URL:          fullURL,
+				Root:         currentDir,
+				Quit:         closeDashboard,
+			}, rl.config)
+		}()
+	} else {
+		close(dashboardDone)
+	}
+
+	handler := trustedProxies.Middleware(logging.Middleware(tracker.Middleware(http.DefaultServeMux), accessLog))
+	var h3 *http3.Server
+	if cfg.TLS.HTTP3 {
+		if useTLS {
+			h3 = newHTTP3Server(cfg.Port, handler)
+			handler = advertiseHTTP3(h3, handler)
+		} else {
+			slog.Warn("HTTP/3 needs TLS, not starting it while serving plain HTTP")
+		}
+	}
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
 		MaxHeaderBytes:    1 << 20,
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       120 * time.Second,
 	}
 
-	log.Fatal(http.ListenAndServeTLS(certPath, keyPath))
+	serveErr := make(chan error, 3)
+	go func() {
+		if useTLS {
+			serveErr <- srv.ListenAndServeTLS(certPath, keyPath)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	if h3 != nil {
+		go func() {
+			if err := h3.ListenAndServeTLS(certPath, keyPath); err != http.ErrServerClosed {
+				serveErr <- err
+			}
+		}()
+		slog.Info("Serving HTTP/3", "port", cfg.Port+"/udp")
+	}
+
+	var redirectSrv *http.Server
+	if cfg.TLS.RedirectPort != "" {
+		if useTLS {
+			redirectSrv = &http.Server{
+				Addr:              ":" + cfg.TLS.RedirectPort,
+				Handler:           proxy.RedirectHandler(cfg.Port),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			go func() {
+				if err := redirectSrv.ListenAndServe(); err != http.ErrServerClosed {
+					serveErr <- err
+				}
+			}()
+			slog.Info("Redirecting HTTP to HTTPS", "port", cfg.TLS.RedirectPort)
+		} else {
+			slog.Warn("Not redirecting to HTTPS while serving plain HTTP", "redirect_port", cfg.TLS.RedirectPort)
+		}
+	}
+
+	select {
+	case err := <-serveErr:
+		fatal("Server stopped", "err", err)
+	case <-ctx.Done():
+	}
+	// A second Ctrl-C kills the process without waiting.
+	stopSignals()
+
+	close(closeDashboard)
+	<-dashboardDone
+	if redirectSrv != nil {
+		redirectSrv.Close()
+	}
+	summary := shutdown(srv, h3, mdnsServer, tracker, stopStreams, time.Duration(rl.config().ShutdownTimeout), uploadPool, downloadPool)
+	summary.print(os.Stdout)
+}
+
+// runDashboard sends logs to the dashboard's event feed while it is open and
+// back to stderr once it is closed.
+func runDashboard(d *dashboard.Dashboard, cfg func() *config.Config) {
+	logging.Setup(cfg().Log.Level, cfg().Log.Format, d.Tracker)
+	quitServer, err := d.Run()
+	logging.Setup(cfg().Log.Level, cfg().Log.Format, os.Stderr)
+	if err != nil {
+		slog.Warn("Dashboard unavailable", "err", err)
+		return
+	}
+	if quitServer {
+		if p, err := os.FindProcess(os.Getpid()); err != nil || p.Signal(os.Interrupt) != nil {
+			os.Exit(130)
+		}
+	}
+}
+
+// certFingerprint returns the hex SHA-256 of the first certificate in a PEM
+// file.
+func certFingerprint(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("%s: no PEM certificate found", path)
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// insideDir reports whether path is dir or somewhere below it.
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fileshare/internal/network"
+	"fileshare/internal/push"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// runPush implements "fileshare push [-to name|url] files...": it finds a
+// receiver on the LAN, waits for it to accept and uploads the files.
+func runPush(args []string) int {
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	to := flags.String("to", "", "Receiver name as shown by \"fileshare discover\", or its URL")
+	fingerprint := flags.String("fingerprint", "", "SHA-256 of the receiver's certificate when -to is a URL")
+	timeout := flags.Duration("timeout", 2*time.Second, "How long to look for receivers")
+	hostName, _ := os.Hostname()
+	from := flags.String("name", hostName, "Name the receiver sees the files coming from")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: fileshare push [-to name|url] [-name sender] file-or-folder...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	files, err := collectFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	baseURL, fp, name, err := chooseReceiver(ctx, *to, *fingerprint, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if fp == "" && strings.HasPrefix(baseURL, "https://") {
+		fmt.Fprintln(os.Stderr, "Warning: the receiver's certificate cannot be verified")
+	}
+
+	sender := &push.Sender{BaseURL: baseURL, Client: push.PinnedClient(fp)}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	offer, err := sender.Offer(ctx, *from, files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Offer failed:", err)
+		return 1
+	}
+	fmt.Printf("Offering %d file(s), %s, to %s. Waiting for them to accept...\n", len(files), formatBytes(total), name)
+	answer, err := sender.Wait(ctx, offer.ID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	start := time.Now()
+	var done int64
+	sender.Progress = func(f push.LocalFile, sent int64) {
+		fmt.Printf("\r\x1b[K%s  %s / %s", f.Name, formatBytes(sent), formatBytes(f.Size))
+	}
+	for _, f := range files {
+		if err := sender.Send(ctx, answer, f); err != nil {
+			fmt.Fprintf(os.Stderr, "\nSending %s failed: %v\n", f.Name, err)
+			return 1
+		}
+		done += f.Size
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("\r\x1b[KSent %d file(s), %s in %s (%s/s)\n", len(files), formatBytes(done),
+		elapsed.Round(100*time.Millisecond), formatBytes(int64(float64(done)/max(elapsed.Seconds(), 0.001))))
+	return 0
+}
+
+// collectFiles expands folders into their files, named relative to the
+// folder's parent so the receiver gets the folder too.
+func collectFiles(paths []string) ([]push.LocalFile, error) {
+	var files []push.LocalFile
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, push.LocalFile{Path: p, File: push.File{Name: filepath.Base(p), Size: info.Size()}})
+			continue
+		}
+		parent := filepath.Dir(filepath.Clean(p))
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(parent, path)
+			files = append(files, push.LocalFile{Path: path, File: push.File{Name: filepath.ToSlash(rel), Size: info.Size()}})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// chooseReceiver resolves -to, or discovers receivers and lets the user
+// pick one when there are several.
+func chooseReceiver(ctx context.Context, to, fingerprint string, timeout time.Duration) (baseURL, fp, name string, err error) {
+	if strings.Contains(to, "://") {
+		return to, fingerprint, to, nil
+	}
+	if to != "" {
+		s, err := network.FindServer(ctx, to, timeout)
+		if err != nil {
+			return "", "", "", err
+		}
+		return s.URL(), s.Fingerprint(), s.Instance, nil
+	}
+
+	servers, err := network.Discover(ctx, timeout)
+	if err != nil {
+		return "", "", "", fmt.Errorf("discovery failed: %w", err)
+	}
+	var receivers []network.Server
+	for _, s := range servers {
+		if s.TXT["push"] == "1" {
+			receivers = append(receivers, s)
+		}
+	}
+	switch {
+	case len(receivers) == 0:
+		return "", "", "", errors.New("no receivers found on the network; is fileshare running there?")
+	case len(receivers) == 1:
+		s := receivers[0]
+		return s.URL(), s.Fingerprint(), s.Instance, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", "", "", errors.New("several receivers found; choose one with -to")
+	}
+	for i, s := range receivers {
+		fmt.Printf("%d) %s  %s\n", i+1, s.Instance, s.URL())
+	}
+	fmt.Print("Send to which receiver? ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(receivers) {
+		return "", "", "", errors.New("no receiver chosen")
+	}
+	s := receivers[n-1]
+	return s.URL(), s.Fingerprint(), s.Instance, nil
+}
+
+// promptOffers asks in the terminal whether to accept each incoming push.
+// It does nothing unless stdin is a terminal; offers can always be answered
+// at /push too.
+func promptOffers(inbox *push.Inbox) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return
+	}
+	go func() {
+		in := bufio.NewReader(os.Stdin)
+		for o := range inbox.Incoming() {
+			fmt.Printf("\n%s (%s) wants to send %d file(s), %s:\n", o.From, o.Client, len(o.Files), formatBytes(o.Total))
+			for i, f := range o.Files {
+				if i == 5 {
+					fmt.Printf("  ... and %d more\n", len(o.Files)-i)
+					break
+				}
+				fmt.Printf("  %s (%s)\n", f.Name, formatBytes(f.Size))
+			}
+			fmt.Print("Accept? [y/N] ")
+			line, err := in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			accept := strings.EqualFold(strings.TrimSpace(line), "y") || strings.EqualFold(strings.TrimSpace(line), "yes")
+			if err := inbox.Answer(o.ID, accept); err != nil {
+				fmt.Println("Too late, the offer was already answered or expired.")
+			} else if accept {
+				fmt.Println("Accepted.")
+			} else {
+				fmt.Println("Declined.")
+			}
+		}
+	}()
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fileshare/internal/cleanup"
+	"fileshare/internal/config"
+	"fileshare/internal/logging"
+	"fileshare/internal/ratelimit"
+	"fileshare/internal/worker"
+	"flag"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// liveSettings are the config keys, or key prefixes ending in ".", that a
+// reload applies to the running server. Other changes need a restart.
+var liveSettings = []string{
+	"limits.",
+	"log.level",
+	"pools.zips_per_client",
+	"pools.zip_timeout",
+	"cleanup.",
+	"shutdown_timeout",
+}
+
+// reloader re-reads the configuration and applies what it can without a
+// restart.
+type reloader struct {
+	args         []string
+	baseDir      string
+	limiter      *ratelimit.Limiter
+	downloadPool *worker.Pool
+	cleanup      *cleanup.Routine
+
+	current atomic.Pointer[config.Config]
+}
+
+func (rl *reloader) config() *config.Config {
+	return rl.current.Load()
+}
+
+func (rl *reloader) reload() {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Bool("tui", false, "")
+	next, err := config.Load(fs, rl.args, rl.baseDir)
+	if err != nil {
+		slog.Error("Config reload failed, keeping the current settings", "err", err)
+		return
+	}
+
+	var applied, skipped []string
+	for _, key := range config.Diff(rl.config(), next) {
+		if isLive(key) {
+			applied = append(applied, key)
+		} else {
+			skipped = append(skipped, key)
+		}
+	}
+
+	rl.limiter.Set(limitsOf(next))
+	logging.SetLevel(next.Log.Level)
+	rl.downloadPool.SetMaxPerClient(next.Pools.ZipsPerClient)
+	rl.downloadPool.SetJobTimeout(time.Duration(next.Pools.ZipTimeout))
+	rl.cleanup.Set(time.Duration(next.Cleanup.MaxAge), time.Duration(next.Cleanup.Interval))
+	rl.current.Store(next)
+
+	slog.Info("Config reloaded", "changed", applied)
+	if len(skipped) > 0 {
+		slog.Warn("Some settings only take effect after a restart", "keys", skipped)
+	}
+}
+
+func isLive(key string) bool {
+	for _, live := range liveSettings {
+		if key == live || strings.HasSuffix(live, ".") && strings.HasPrefix(key, live) {
+			return true
+		}
+	}
+	return false
+}
+
+func limitsOf(cfg *config.Config) ratelimit.Limits {
+	return ratelimit.Limits{
+		Up:         int64(cfg.Limits.Up),
+		Down:       int64(cfg.Limits.Down),
+		ClientUp:   int64(cfg.Limits.ClientUp),
+		ClientDown: int64(cfg.Limits.ClientDown),
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fileshare/internal/activity"
+	"fileshare/internal/network"
+	"fileshare/internal/worker"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// shutdownSummary describes what the server was doing when it stopped.
+type shutdownSummary struct {
+	uptime      time.Duration
+	requests    int64
+	clients     int
+	drained     bool
+	waited      time.Duration
+	interrupted []activity.RequestInfo
+	uploads     []activity.Upload
+}
+
+// shutdown stops advertising and accepting connections, gives in-flight
+// requests until timeout to finish, then cuts off whatever is left and stops
+// the worker pools.
+func shutdown(srv *http.Server, h3 *http3.Server, mdns *network.MDNS, tracker *activity.Tracker, stopStreams chan struct{},
+	timeout time.Duration, pools ...*worker.Pool) shutdownSummary {
+	start := time.Now()
+	active := 0
+	for _, req := range tracker.Snapshot().Requests {
+		if req.Path != "/events" {
+			active++
+		}
+	}
+	slog.Info("Shutting down", "active_requests", active, "timeout", timeout)
+
+	mdns.Shutdown()
+	close(stopStreams)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if h3 != nil {
+		// Refuses new QUIC connections and sends GOAWAY. Its own wait covers
+		// idle connections of clients that vanished, so requests are
+		// waited for through the tracker instead.
+		go h3.Shutdown(ctx)
+	}
+	err := srv.Shutdown(ctx)
+	if err == nil && h3 != nil {
+		err = waitForRequests(ctx, tracker)
+	}
+
+	// Whatever is still running now is about to be cut off.
+	snap := tracker.Snapshot()
+	summary := shutdownSummary{
+		uptime:  time.Since(snap.Started),
+		clients: len(snap.Clients),
+		drained: err == nil,
+		waited:  time.Since(start),
+		uploads: snap.Uploads,
+	}
+	for _, c := range snap.Clients {
+		summary.requests += c.Requests
+	}
+	if err != nil {
+		slog.Warn("Transfers did not finish in time, closing connections", "err", err)
+		for _, req := range snap.Requests {
+			if req.Path != "/events" {
+				summary.interrupted = append(summary.interrupted, req)
+			}
+		}
+		srv.Close()
+	}
+	if h3 != nil {
+		h3.Close()
+	}
+
+	for _, p := range pools {
+		p.Stop()
+	}
+	return summary
+}
+
+// waitForRequests waits until no request other than an event stream is in
+// flight, or ctx is done.
+func waitForRequests(ctx context.Context, tracker *activity.Tracker) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		busy := false
+		for _, req := range tracker.Snapshot().Requests {
+			if req.Path != "/events" {
+				busy = true
+				break
+			}
+		}
+		if !busy {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s shutdownSummary) print(w io.Writer) {
+	fmt.Fprintf(w, "\n--- Server Stopped ---\n")
+	fmt.Fprintf(w, "Uptime: %s, %d requests from %d clients\n", s.uptime.Round(time.Second), s.requests, s.clients)
+	if s.drained {
+		fmt.Fprintf(w, "All transfers finished (waited %s)\n", s.waited.Round(time.Millisecond))
+	} else {
+		fmt.Fprintf(w, "Interrupted %d requests after %s:\n", len(s.interrupted), s.waited.Round(time.Second))
+		for _, req := range s.interrupted {
+			target := req.Path
+			if req.Query != "" {
+				target += "?" + req.Query
+			}
+			fmt.Fprintf(w, "  %s %s for %s (%d bytes in, %d out)\n", req.Method, target, req.Client, req.BytesIn, req.BytesOut)
+		}
+	}
+	if len(s.uploads) > 0 {
+		fmt.Fprintf(w, "Incomplete uploads (partial files are kept until cleanup):\n")
+		for _, u := range s.uploads {
+			progress := fmt.Sprintf("%d bytes", u.Received)
+			if u.Size > 0 {
+				progress = fmt.Sprintf("%d of %d bytes", u.Received, u.Size)
+			}
+			fmt.Fprintf(w, "  %s from %s, %s\n", strings.TrimPrefix(u.Path, "/"), u.Client, progress)
+		}
+	}
+}
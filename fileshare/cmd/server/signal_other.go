@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "fileshare/internal/ratelimit"
+
+// watchLimitSignal is a no-op where SIGUSR1 does not exist; use /admin instead.
+func watchLimitSignal(limiter *ratelimit.Limiter) {}
+
+// watchReloadSignal is a no-op without SIGHUP; restart to pick up changes.
+func watchReloadSignal(reload func()) {}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package main
+
+import (
+	"fileshare/internal/ratelimit"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLimitSignal toggles bandwidth limits on SIGUSR1, e.g. to let a large
+// transfer through after hours without restarting the server.
+func watchLimitSignal(limiter *ratelimit.Limiter) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	go func() {
+		for range sigs {
+			enabled := !limiter.Enabled()
+			limiter.SetEnabled(enabled)
+			slog.Info("Bandwidth limits toggled", "enabled", enabled, "limits", limiter.Limits())
+		}
+	}()
+}
+
+// watchReloadSignal calls reload on SIGHUP.
+func watchReloadSignal(reload func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			reload()
+		}
+	}()
+}
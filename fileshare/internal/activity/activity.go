@@ -0,0 +1,457 @@
+// Package activity
+package activity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxEvents      = 200
+	throughputSecs = 60
+	uploadIdle     = 2 * time.Minute
+	clientIdle     = 30 * time.Minute
+)
+
+type ctxKey struct{}
+
+// Request is an HTTP request that is currently being served.
+type Request struct {
+	ID      int64
+	Client  string
+	Method  string
+	Path    string
+	Query   string
+	Started time.Time
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	cancel   context.CancelFunc
+}
+
+// RequestInfo is a point-in-time copy of a Request.
+type RequestInfo struct {
+	ID       int64     `json:"id"`
+	Client   string    `json:"client"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytesIn"`
+	BytesOut int64     `json:"bytesOut"`
+}
+
+// Upload is a chunked upload that has received data but not been finalized.
+type Upload struct {
+	ID       string    `json:"id"`
+	Client   string    `json:"client"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Received int64     `json:"received"`
+	Started  time.Time `json:"started"`
+	Updated  time.Time `json:"updated"`
+}
+
+// ClientInfo summarises traffic from one remote address.
+type ClientInfo struct {
+	Addr      string    `json:"addr"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Requests  int64     `json:"requests"`
+	Active    int       `json:"active"`
+	BytesIn   int64     `json:"bytesIn"`
+	BytesOut  int64     `json:"bytesOut"`
+	Kicked    bool      `json:"kicked,omitempty"`
+}
+
+// Event is a line for the recent events feed.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Sample is the number of bytes moved during one second.
+type Sample struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+// Snapshot is a consistent copy of the tracker state for display.
+type Snapshot struct {
+	Started       time.Time     `json:"started"`
+	Clients       []ClientInfo  `json:"clients"`
+	Requests      []RequestInfo `json:"requests"`
+	Uploads       []Upload      `json:"uploads"`
+	Events        []Event       `json:"events"`
+	Throughput    []Sample      `json:"throughput"`
+	UploadsPaused bool          `json:"uploadsPaused"`
+}
+
+// Tracker records who is connected and what they are transferring. Methods
+// are safe to call on a nil Tracker.
+type Tracker struct {
+	started time.Time
+	nextID  atomic.Int64
+	paused  atomic.Bool
+
+	secIn  atomic.Int64
+	secOut atomic.Int64
+
+	mu         sync.Mutex
+	clients    map[string]*ClientInfo
+	requests   map[int64]*Request
+	uploads    map[string]*Upload
+	kicked     map[string]time.Time
+	events     []Event
+	throughput []Sample
+	partial    []byte
+}
+
+// New creates a tracker and starts its throughput sampler.
+func New() *Tracker {
+	t := &Tracker{
+		started:  time.Now(),
+		clients:  map[string]*ClientInfo{},
+		requests: map[int64]*Request{},
+		uploads:  map[string]*Upload{},
+		kicked:   map[string]time.Time{},
+	}
+	go t.sample()
+	return t
+}
+
+// FromContext returns the tracker serving this request, or nil.
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(ctxKey{}).(*Tracker)
+	return t
+}
+
+type userKey struct{}
+
+// WithUser marks r as sent by user. Call it only once the user's
+// credentials have been checked.
+func WithUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+}
+
+// User returns the verified user set by WithUser, or "" for anonymous
+// requests. Unchecked Authorization headers are never trusted.
+func User(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// ClientAddr returns the host part of a request's RemoteAddr.
+func ClientAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Middleware tracks every request, counts its bytes in both directions and
+// turns away clients that were kicked.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := ClientAddr(r)
+		if t.isKicked(client) {
+			http.Error(w, "Disconnected by the server operator", http.StatusForbidden)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.WithValue(r.Context(), ctxKey{}, t))
+		defer cancel()
+		req := &Request{
+			ID:      t.nextID.Add(1),
+			Client:  client,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   r.URL.RawQuery,
+			Started: time.Now(),
+			cancel:  cancel,
+		}
+		t.begin(req)
+		defer t.end(req)
+
+		if r.Body != nil {
+			r.Body = &countingBody{ReadCloser: r.Body, t: t, req: req}
+		}
+		next.ServeHTTP(&countingWriter{ResponseWriter: w, t: t, req: req}, r.WithContext(ctx))
+	})
+}
+
+func (t *Tracker) begin(req *Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.clients[req.Client]
+	if c == nil {
+		c = &ClientInfo{Addr: req.Client, FirstSeen: req.Started}
+		t.clients[req.Client] = c
+		t.addEvent(fmt.Sprintf("New client %s", req.Client))
+	}
+	c.LastSeen = req.Started
+	c.Requests++
+	c.Active++
+	t.requests[req.ID] = req
+}
+
+func (t *Tracker) end(req *Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.requests, req.ID)
+	if c := t.clients[req.Client]; c != nil {
+		c.Active--
+		c.LastSeen = time.Now()
+		c.BytesIn += req.bytesIn.Load()
+		c.BytesOut += req.bytesOut.Load()
+	}
+}
+
+// Kick cancels every request from client and refuses new ones for d.
+func (t *Tracker) Kick(client string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.kicked[client] = time.Now().Add(d)
+	for _, req := range t.requests {
+		if req.Client == client {
+			req.cancel()
+		}
+	}
+	for id, u := range t.uploads {
+		if u.Client == client {
+			delete(t.uploads, id)
+		}
+	}
+	t.addEvent(fmt.Sprintf("Kicked %s for %v", client, d))
+}
+
+// Cancel aborts a single in-flight request.
+func (t *Tracker) Cancel(id int64) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	req, ok := t.requests[id]
+	if ok {
+		req.cancel()
+		t.addEvent(fmt.Sprintf("Cancelled %s %s for %s", req.Method, req.Path, req.Client))
+	}
+	return ok
+}
+
+func (t *Tracker) isKicked(client string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.kicked[client]
+	if ok && time.Now().After(until) {
+		delete(t.kicked, client)
+		return false
+	}
+	return ok
+}
+
+// SetUploadsPaused pauses or resumes chunk uploads.
+func (t *Tracker) SetUploadsPaused(paused bool) {
+	if t == nil {
+		return
+	}
+	if t.paused.Swap(paused) != paused {
+		state := "resumed"
+		if paused {
+			state = "paused"
+		}
+		t.Event("Uploads %s", state)
+	}
+}
+
+// UploadsPaused reports whether new chunks should be refused.
+func (t *Tracker) UploadsPaused() bool {
+	return t != nil && t.paused.Load()
+}
+
+// UploadChunk records n bytes written for upload id. size is the total file
+// size reported by the client, or 0 if unknown.
+func (t *Tracker) UploadChunk(r *http.Request, id, path string, size, n int64) {
+	if t == nil || id == "" {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.uploads[id]
+	if u == nil {
+		u = &Upload{ID: id, Client: ClientAddr(r), Path: path, Started: now}
+		t.uploads[id] = u
+		t.addEvent(fmt.Sprintf("Upload started: %s from %s", path, u.Client))
+	}
+	if size > 0 {
+		u.Size = size
+	}
+	u.Received += n
+	u.Updated = now
+}
+
+// UploadDone removes a finalized upload.
+func (t *Tracker) UploadDone(id, path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.uploads, id)
+	t.addEvent(fmt.Sprintf("Upload complete: %s", path))
+}
+
+// Event adds a formatted line to the recent events feed.
+func (t *Tracker) Event(format string, args ...any) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addEvent(fmt.Sprintf(format, args...))
+}
+
+func (t *Tracker) addEvent(msg string) {
+	t.events = append(t.events, Event{Time: time.Now(), Message: msg})
+	if len(t.events) > maxEvents {
+		t.events = t.events[len(t.events)-maxEvents:]
+	}
+}
+
+// Write lets the tracker act as a log sink; each line becomes an event.
+func (t *Tracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.addEvent(string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+var _ io.Writer = (*Tracker)(nil)
+
+// Snapshot copies the current state.
+func (t *Tracker) Snapshot() Snapshot {
+	if t == nil {
+		return Snapshot{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Snapshot{Started: t.started, UploadsPaused: t.paused.Load()}
+	live := map[string][2]int64{}
+	for _, req := range t.requests {
+		info := RequestInfo{
+			ID: req.ID, Client: req.Client, Method: req.Method, Path: req.Path, Query: req.Query,
+			Started: req.Started, BytesIn: req.bytesIn.Load(), BytesOut: req.bytesOut.Load(),
+		}
+		s.Requests = append(s.Requests, info)
+		b := live[req.Client]
+		live[req.Client] = [2]int64{b[0] + info.BytesIn, b[1] + info.BytesOut}
+	}
+	for _, c := range t.clients {
+		info := *c
+		info.BytesIn += live[c.Addr][0]
+		info.BytesOut += live[c.Addr][1]
+		_, info.Kicked = t.kicked[c.Addr]
+		s.Clients = append(s.Clients, info)
+	}
+	for _, u := range t.uploads {
+		s.Uploads = append(s.Uploads, *u)
+	}
+	s.Events = append(s.Events, t.events...)
+	s.Throughput = append(s.Throughput, t.throughput...)
+
+	sort.Slice(s.Clients, func(i, j int) bool { return s.Clients[i].Addr < s.Clients[j].Addr })
+	sort.Slice(s.Requests, func(i, j int) bool { return s.Requests[i].ID < s.Requests[j].ID })
+	sort.Slice(s.Uploads, func(i, j int) bool { return s.Uploads[i].Started.Before(s.Uploads[j].Started) })
+	return s
+}
+
+// sample rolls the per-second byte counters into the throughput history and
+// prunes idle state.
+func (t *Tracker) sample() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		in, out := t.secIn.Swap(0), t.secOut.Swap(0)
+		t.mu.Lock()
+		t.throughput = append(t.throughput, Sample{In: in, Out: out})
+		if len(t.throughput) > throughputSecs {
+			t.throughput = t.throughput[len(t.throughput)-throughputSecs:]
+		}
+		t.prune(now)
+		t.mu.Unlock()
+	}
+}
+
+// prune forgets uploads that stopped sending chunks, clients that have had
+// no request in flight for clientIdle and kicks that have run out, so a
+// long-running server does not keep every address it has ever seen. Callers
+// hold t.mu.
+func (t *Tracker) prune(now time.Time) {
+	for id, u := range t.uploads {
+		if now.Sub(u.Updated) > uploadIdle {
+			delete(t.uploads, id)
+		}
+	}
+	for addr, until := range t.kicked {
+		if now.After(until) {
+			delete(t.kicked, addr)
+		}
+	}
+	for addr, c := range t.clients {
+		if _, kicked := t.kicked[addr]; c.Active == 0 && !kicked && now.Sub(c.LastSeen) > clientIdle {
+			delete(t.clients, addr)
+		}
+	}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	t   *Tracker
+	req *Request
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.req.bytesIn.Add(int64(n))
+	b.t.secIn.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	t   *Tracker
+	req *Request
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.req.bytesOut.Add(int64(n))
+	w.t.secOut.Add(int64(n))
+	return n, err
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,56 @@
+package activity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	tr := &Tracker{
+		clients:  map[string]*ClientInfo{},
+		requests: map[int64]*Request{},
+		uploads:  map[string]*Upload{},
+		kicked:   map[string]time.Time{},
+	}
+	now := time.Now()
+	for _, c := range []ClientInfo{
+		{Addr: "10.0.0.1", LastSeen: now.Add(-time.Minute)},
+		{Addr: "10.0.0.2", LastSeen: now.Add(-time.Hour)},
+		// A long download counts as activity however old its start is.
+		{Addr: "10.0.0.3", LastSeen: now.Add(-time.Hour), Active: 1},
+		{Addr: "10.0.0.4", LastSeen: now.Add(-time.Hour)},
+		{Addr: "10.0.0.5", LastSeen: now.Add(-time.Hour)},
+	} {
+		tr.clients[c.Addr] = &c
+	}
+	// A kicked client stays listed until its kick runs out.
+	tr.kicked["10.0.0.4"] = now.Add(time.Minute)
+	tr.kicked["10.0.0.5"] = now.Add(-time.Minute)
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	tr.UploadChunk(req, "fresh", "/a.bin", 2, 1)
+	tr.UploadChunk(req, "stale", "/b.bin", 2, 1)
+	tr.uploads["stale"].Updated = now.Add(-time.Hour)
+
+	tr.mu.Lock()
+	tr.prune(now)
+	tr.mu.Unlock()
+
+	snap := tr.Snapshot()
+	var clients []string
+	for _, c := range snap.Clients {
+		clients = append(clients, c.Addr)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.3", "10.0.0.4"}; !slices.Equal(clients, want) {
+		t.Errorf("clients = %q, want %q", clients, want)
+	}
+	if len(snap.Uploads) != 1 || snap.Uploads[0].ID != "fresh" {
+		t.Errorf("uploads = %+v, want only fresh", snap.Uploads)
+	}
+	if _, ok := tr.kicked["10.0.0.5"]; ok {
+		t.Error("expired kick kept")
+	}
+}
@@ -0,0 +1,248 @@
+// Package audit
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fileshare/internal/activity"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit trail.
+const (
+	ActionUpload   = "upload"
+	ActionDownload = "download"
+	ActionZip      = "zip"
+	ActionDelete   = "delete"
+	ActionShare    = "share"
+	ActionRevoke   = "revoke"
+)
+
+// Record is one line of the audit log. Hash covers every other field plus
+// the previous record's hash, so editing or removing a line breaks the chain.
+type Record struct {
+	Seq      int64     `json:"seq"`
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Identity string    `json:"identity,omitempty"`
+	Action   string    `json:"action"`
+	Path     string    `json:"path"`
+	Bytes    int64     `json:"bytes"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Prev     string    `json:"prev"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+func (r Record) computeHash() string {
+	r.Hash = ""
+	body, _ := json.Marshal(r)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log is an append-only, hash-chained JSON lines file. A nil Log records
+// nothing, so callers need not check whether auditing is enabled.
+type Log struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	seq  int64
+	last string
+}
+
+// Open appends to the audit log at path, continuing the existing chain.
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+	if err := scan(path, func(rec Record) error {
+		l.seq, l.last = rec.Seq, rec.Hash
+		return nil
+	}); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.f, l.size = f, info.Size()
+	return l, nil
+}
+
+// Close closes the file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Record appends an entry for a request. sum is the hex SHA-256 of the file
+// content when known.
+func (l *Log) Record(r *http.Request, action, path string, bytes int64, sum string) {
+	if l == nil {
+		return
+	}
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+	// Only identities a handler has verified are recorded.
+	identity := activity.User(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec := Record{
+		Seq:      l.seq + 1,
+		Time:     time.Now().UTC(),
+		Client:   client,
+		Identity: identity,
+		Action:   action,
+		Path:     path,
+		Bytes:    bytes,
+		SHA256:   sum,
+		Prev:     l.last,
+	}
+	rec.Hash = rec.computeHash()
+
+	line, _ := json.Marshal(rec)
+	line = append(line, '\n')
+	if _, err := l.f.Write(line); err != nil {
+		slog.Error("Audit write failed", "err", err)
+		return
+	}
+	if err := l.f.Sync(); err != nil {
+		slog.Error("Audit sync failed", "err", err)
+	}
+	l.size += int64(len(line))
+	l.seq, l.last = rec.Seq, rec.Hash
+}
+
+// Tail returns up to n of the newest records, newest first.
+func (l *Log) Tail(n int) ([]Record, error) {
+	l.mu.Lock()
+	path := l.path
+	l.mu.Unlock()
+
+	var ring []Record
+	err := scan(path, func(rec Record) error {
+		ring = append(ring, rec)
+		if len(ring) > n {
+			ring = ring[1:]
+		}
+		return nil
+	})
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+	return ring, err
+}
+
+// Verify checks the whole chain at path and returns the number of valid
+// records. The error names the first line that does not match.
+func Verify(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, _, err := verify(f)
+	return n, err
+}
+
+// Verify checks this log's chain on disk up to the last record written when
+// it was called, without holding up Record while it reads. The chain must end
+// at the hash this Log wrote last, so lines cut from the end are caught too.
+func (l *Log) Verify() (int64, error) {
+	l.mu.Lock()
+	size, seq, last := l.size, l.seq, l.last
+	l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, prev, err := verify(io.LimitReader(f, size))
+	switch {
+	case err != nil:
+	case n != seq:
+		err = fmt.Errorf("line %d: log ends here but %d records were written", n+1, seq)
+	case prev != last:
+		err = fmt.Errorf("line %d: record was rewritten since it was logged", n)
+	}
+	return n, err
+}
+
+func verify(r io.Reader) (int64, string, error) {
+	var seq int64
+	prev := ""
+	err := scanReader(r, func(rec Record) error {
+		switch {
+		case rec.Seq != seq+1:
+			return fmt.Errorf("line %d: sequence %d, expected %d", seq+1, rec.Seq, seq+1)
+		case rec.Prev != prev:
+			return fmt.Errorf("line %d: previous hash does not match line %d", seq+1, seq)
+		case rec.computeHash() != rec.Hash:
+			return fmt.Errorf("line %d: record hash mismatch, entry was modified", seq+1)
+		}
+		seq, prev = rec.Seq, rec.Hash
+		return nil
+	})
+	return seq, prev, err
+}
+
+func scan(path string, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanReader(f, fn)
+}
+
+func scanReader(r io.Reader, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// HashFile returns the hex SHA-256 of a file in fsys.
+func HashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// openTestLog returns a log in a temporary directory holding n uploads.
+func openTestLog(t *testing.T, n int) (*Log, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	for range n {
+		l.Record(httptest.NewRequest(http.MethodPost, "/upload", nil), ActionUpload, "/a.txt", 5, "")
+	}
+	return l, path
+}
+
+func TestLogVerifyWhileRecording(t *testing.T) {
+	l, _ := openTestLog(t, 1)
+
+	// Records appended while a check runs are left for the next one.
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for range 200 {
+			l.Record(httptest.NewRequest(http.MethodGet, "/a.txt", nil), ActionDownload, "/a.txt", 5, "")
+		}
+	})
+	for range 20 {
+		if _, err := l.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if n, err := l.Verify(); n != 201 || err != nil {
+		t.Errorf("Verify() = %d, %v, want 201 records", n, err)
+	}
+}
+
+func TestLogVerifyTruncated(t *testing.T) {
+	l, path := openTestLog(t, 3)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first two lines still form a valid chain on their own.
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if err := os.WriteFile(path, bytes.Join(lines[:2], nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Verify(path); n != 2 || err != nil {
+		t.Errorf("Verify(path) = %d, %v, want 2 valid records", n, err)
+	}
+	if n, err := l.Verify(); n != 2 || err == nil {
+		t.Errorf("(*Log).Verify() = %d, %v, want the cut caught", n, err)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		valid  int64
+	}{
+		{name: "intact", tamper: func(lines [][]byte) [][]byte { return lines }, valid: 4},
+		{name: "edited", valid: 1, tamper: func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"bytes":5`), []byte(`"bytes":6`), 1)
+			return lines
+		}},
+		{name: "removed", valid: 1, tamper: func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}},
+		{name: "swapped", valid: 1, tamper: func(lines [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}},
+		{name: "first removed", valid: 0, tamper: func(lines [][]byte) [][]byte {
+			return lines[1:]
+		}},
+		{name: "rehashed edit", valid: 3, tamper: func(lines [][]byte) [][]byte {
+			// Fixing up the edited record's own hash still breaks the link
+			// from the next one.
+			var rec Record
+			json.Unmarshal(lines[2], &rec)
+			rec.Path = "/other.txt"
+			rec.Hash = rec.computeHash()
+			lines[2], _ = json.Marshal(rec)
+			lines[2] = append(lines[2], '\n')
+			return lines
+		}},
+		{name: "not json", valid: 3, tamper: func(lines [][]byte) [][]byte {
+			lines[3] = []byte("{oops\n")
+			return lines
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, path := openTestLog(t, 4)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := bytes.SplitAfter(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+			lines[len(lines)-1] = append(lines[len(lines)-1], '\n')
+			if err := os.WriteFile(path, bytes.Join(tt.tamper(lines), nil), 0600); err != nil {
+				t.Fatal(err)
+			}
+			n, err := Verify(path)
+			if n != tt.valid || (err == nil) != (tt.valid == 4) {
+				t.Errorf("Verify = %d, %v, want %d valid records", n, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestOpenContinuesChain(t *testing.T) {
+	l, path := openTestLog(t, 2)
+	l.Close()
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Record(httptest.NewRequest(http.MethodPost, "/upload", nil), ActionUpload, "/b.txt", 1, "")
+	if n, err := l.Verify(); n != 3 || err != nil {
+		t.Errorf("Verify after reopening = %d, %v, want 3 records", n, err)
+	}
+	records, err := l.Tail(1)
+	if err != nil || len(records) != 1 || records[0].Seq != 3 || records[0].Path != "/b.txt" {
+		t.Errorf("Tail(1) = %+v, %v", records, err)
+	}
+}
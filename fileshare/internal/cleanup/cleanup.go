@@ -2,43 +2,163 @@
 package cleanup
 
 import (
-	"log"
-	"os"
-	"path/filepath"
+	"fileshare/internal/metrics"
+	"fileshare/internal/storage"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Partial is an unfinished upload left on disk.
+type Partial struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Routine periodically removes stale .partial files and remembers the ones
+// left, so they can be listed without walking the share. Its policy can be
+// changed while it runs.
+type Routine struct {
+	store storage.FS
+	reset chan struct{}
+
+	mu       sync.Mutex
+	maxAge   time.Duration
+	interval time.Duration
+	partials []Partial
+	listed   time.Time
+}
+
 // StartCleanupRoutine : a goroutine that cleans up old .partial files
-func StartCleanupRoutine(baseDir string, maxAge time.Duration, interval time.Duration) {
+func StartCleanupRoutine(store storage.FS, maxAge time.Duration, interval time.Duration) *Routine {
+	r := &Routine{store: store, reset: make(chan struct{}, 1), maxAge: maxAge, interval: interval}
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
-		cleanPartialFiles(baseDir, maxAge)
-		for range ticker.C {
-			cleanPartialFiles(baseDir, maxAge)
+		r.Run()
+		for {
+			select {
+			case <-ticker.C:
+				r.Run()
+			case <-r.reset:
+				_, interval := r.Policy()
+				ticker.Reset(interval)
+			}
 		}
 	}()
-	log.Printf("Cleanup routine started: Checking every %v for files older than %v", interval, maxAge)
+	slog.Info("Cleanup routine started", "interval", interval, "max_age", maxAge)
+	return r
+}
+
+// Run removes partial files older than the current MaxAge now.
+func (r *Routine) Run() int {
+	return len(r.Clean(r.MaxAge(), nil))
+}
+
+// Clean removes partial files as CleanPartialFiles does and keeps the list
+// of those left for Partials.
+func (r *Routine) Clean(maxAge time.Duration, keep func(name string) bool) []Partial {
+	listed := time.Now()
+	removed, left := sweep(r.store, maxAge, keep)
+	r.mu.Lock()
+	r.partials, r.listed = left, listed
+	r.mu.Unlock()
+	return removed
+}
+
+// Partials returns the partial files found by the last sweep and when it ran.
+// Uploads started since then are not included.
+func (r *Routine) Partials() ([]Partial, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.partials), r.listed
+}
+
+func (r *Routine) MaxAge() time.Duration {
+	maxAge, _ := r.Policy()
+	return maxAge
+}
+
+// Policy returns the current settings.
+func (r *Routine) Policy() (maxAge, interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.maxAge, r.interval
 }
 
-func cleanPartialFiles(baseDir string, maxAge time.Duration) {
+// Set changes the policy; a new interval takes effect from now.
+func (r *Routine) Set(maxAge, interval time.Duration) {
+	r.mu.Lock()
+	changed := interval != r.interval
+	r.maxAge, r.interval = maxAge, interval
+	r.mu.Unlock()
+	if changed {
+		select {
+		case r.reset <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// PartialName is where the unfinished upload of the file name is kept.
+func PartialName(name string) string {
+	return path.Join(path.Dir(name), "."+path.Base(name)+".partial")
+}
+
+// CleanPartialFiles removes .partial files older than maxAge and returns the
+// ones it deleted. Partials for which keep returns true are left alone; keep
+// may be nil.
+func CleanPartialFiles(store storage.FS, maxAge time.Duration, keep func(name string) bool) []Partial {
+	removed, _ := sweep(store, maxAge, keep)
+	return removed
+}
+
+// sweep walks store once, removing stale partials and listing the rest.
+func sweep(store storage.FS, maxAge time.Duration, keep func(name string) bool) (removed, left []Partial) {
 	cutoff := time.Now().Add(-maxAge)
 
-	filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+	fs.WalkDir(store, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), ".partial") {
 			return nil
 		}
 
-		if !strings.HasSuffix(info.Name(), ".partial") {
+		info, err := d.Info()
+		if err != nil {
 			return nil
 		}
-
-		if info.ModTime().Before(cutoff) {
-			if err := os.Remove(path); err == nil {
-				log.Printf("Cleaned up partial: %s (age: %v)", info.Name(), time.Since(info.ModTime()))
+		p := Partial{Path: name, Size: info.Size(), ModTime: info.ModTime()}
+		if (keep == nil || !keep(name)) && info.ModTime().Before(cutoff) {
+			if err := store.Remove(name); err == nil {
+				removed = append(removed, p)
+				metrics.PartialsReaped.Inc()
+				slog.Info("Cleaned up partial", "file", info.Name(), "age", time.Since(info.ModTime()).Round(time.Second))
+				return nil
 			}
 		}
+		left = append(left, p)
+		return nil
+	})
+	return removed, left
+}
+
+// ListPartials returns every .partial file in store.
+func ListPartials(store storage.FS) []Partial {
+	var partials []Partial
+	fs.WalkDir(store, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), ".partial") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		partials = append(partials, Partial{Path: name, Size: info.Size(), ModTime: info.ModTime()})
 		return nil
 	})
+	return partials
 }
@@ -0,0 +1,521 @@
+// Package config
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fileshare/internal/proxy"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment override. A key such as
+// limits.client_down is read from FILESHARE_LIMITS_CLIENT_DOWN.
+const EnvPrefix = "FILESHARE_"
+
+// Config holds every server setting. Values come from Default, then the
+// config file, then FILESHARE_* environment variables, then flags.
+type Config struct {
+	Port            string   `yaml:"port" toml:"port"`
+	Root            string   `yaml:"root" toml:"root"`
+	ShowHidden      bool     `yaml:"show_hidden" toml:"show_hidden"`
+	Ignore          []string `yaml:"ignore" toml:"ignore"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// TrustedProxies lists the IPs and CIDR ranges whose X-Forwarded-For
+	// and X-Forwarded-Proto headers are believed.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// Interfaces picks, by name or IP, the addresses shown in the URL and
+	// QR code and announced over mDNS. Empty picks every real interface.
+	Interfaces []string `yaml:"interfaces" toml:"interfaces"`
+
+	TLS     TLS     `yaml:"tls" toml:"tls"`
+	Admin   Admin   `yaml:"admin" toml:"admin"`
+	Limits  Limits  `yaml:"limits" toml:"limits"`
+	Pools   Pools   `yaml:"pools" toml:"pools"`
+	Cleanup Cleanup `yaml:"cleanup" toml:"cleanup"`
+	MDNS    MDNS    `yaml:"mdns" toml:"mdns"`
+	QR      QR      `yaml:"qr" toml:"qr"`
+	Upload  Upload  `yaml:"upload" toml:"upload"`
+	Index   Index   `yaml:"index" toml:"index"`
+	Log     Log     `yaml:"log" toml:"log"`
+}
+
+type TLS struct {
+	// Mode is on, off, or auto to serve HTTPS only when Cert and Key exist.
+	Mode string `yaml:"mode" toml:"mode"`
+	// Cert and Key default to cert.pem and key.pem next to the binary.
+	Cert string `yaml:"cert" toml:"cert"`
+	Key  string `yaml:"key" toml:"key"`
+	// RedirectPort, if set, serves plain HTTP redirects to HTTPS.
+	RedirectPort string `yaml:"redirect_port" toml:"redirect_port"`
+	// HTTP3 also serves HTTP/3 over QUIC on the same port number (UDP).
+	HTTP3 bool `yaml:"http3" toml:"http3"`
+}
+
+type Admin struct {
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	// LinksOnly serves files only to visitors who opened a share link made
+	// on the admin page, and only inside the folder it was made for.
+	LinksOnly bool `yaml:"links_only" toml:"links_only"`
+}
+
+// Limits are bandwidth caps in bytes per second; zero is unlimited.
+type Limits struct {
+	Up         Size `yaml:"up" toml:"up"`
+	Down       Size `yaml:"down" toml:"down"`
+	ClientUp   Size `yaml:"client_up" toml:"client_up"`
+	ClientDown Size `yaml:"client_down" toml:"client_down"`
+}
+
+type Pools struct {
+	UploadWorkers   int      `yaml:"upload_workers" toml:"upload_workers"`
+	UploadQueue     int      `yaml:"upload_queue" toml:"upload_queue"`
+	DownloadWorkers int      `yaml:"download_workers" toml:"download_workers"`
+	DownloadQueue   int      `yaml:"download_queue" toml:"download_queue"`
+	ZipsPerClient   int      `yaml:"zips_per_client" toml:"zips_per_client"`
+	ZipTimeout      Duration `yaml:"zip_timeout" toml:"zip_timeout"`
+}
+
+type Cleanup struct {
+	MaxAge   Duration `yaml:"max_age" toml:"max_age"`
+	Interval Duration `yaml:"interval" toml:"interval"`
+}
+
+type MDNS struct {
+	// Name is the host name, reachable as Name.local.
+	Name string `yaml:"name" toml:"name"`
+	// Instance is the service name shown when browsing; it defaults to Name.
+	Instance string `yaml:"instance" toml:"instance"`
+}
+
+type QR struct {
+	// URL picks what the code opens: ip, mdns (the NAME.local address),
+	// admin (the /admin page with the admin credentials embedded) or share
+	// (a share link to the whole folder, made at startup and revocable on
+	// the admin page).
+	URL string `yaml:"url" toml:"url"`
+	// Invert swaps dark and light for terminals with a light background.
+	Invert bool `yaml:"invert" toml:"invert"`
+	// Compact trims the quiet zone around the code to one module.
+	Compact bool `yaml:"compact" toml:"compact"`
+	// File also writes the code as an image; the extension, .png or .svg,
+	// picks the format.
+	File string `yaml:"file" toml:"file"`
+	// Serve shows the code at /qr for display on another screen.
+	Serve bool `yaml:"serve" toml:"serve"`
+}
+
+type Upload struct {
+	ChunkSize      Size `yaml:"chunk_size" toml:"chunk_size"`
+	ParallelChunks int  `yaml:"parallel_chunks" toml:"parallel_chunks"`
+}
+
+type Index struct {
+	File           string `yaml:"file" toml:"file"`
+	ContentFile    string `yaml:"content_file" toml:"content_file"`
+	ContentMaxSize Size   `yaml:"content_max_size" toml:"content_max_size"`
+}
+
+type Log struct {
+	Level           string `yaml:"level" toml:"level"`
+	Format          string `yaml:"format" toml:"format"`
+	AccessFile      string `yaml:"access_file" toml:"access_file"`
+	AccessFormat    string `yaml:"access_format" toml:"access_format"`
+	AccessMaxSizeMB int64  `yaml:"access_max_size_mb" toml:"access_max_size_mb"`
+	AccessBackups   int    `yaml:"access_backups" toml:"access_backups"`
+	AuditFile       string `yaml:"audit_file" toml:"audit_file"`
+}
+
+// Default returns the settings used when nothing else is configured.
+func Default() *Config {
+	return &Config{
+		Port:            "8080",
+		ShutdownTimeout: Duration(30 * time.Second),
+		TLS:             TLS{Mode: "on"},
+		Admin:           Admin{User: "admin"},
+		Pools: Pools{
+			UploadWorkers:   runtime.NumCPU(),
+			UploadQueue:     500,
+			DownloadWorkers: 4,
+			DownloadQueue:   20,
+			ZipsPerClient:   2,
+		},
+		Cleanup: Cleanup{MaxAge: Duration(24 * time.Hour), Interval: Duration(time.Hour)},
+		MDNS:    MDNS{Name: "fileshare"},
+		QR:      QR{URL: "ip", Serve: true},
+		Upload:  Upload{ChunkSize: 4 << 20, ParallelChunks: 4},
+		Index:   Index{ContentMaxSize: 1 << 20},
+		Log: Log{
+			Level:           "info",
+			Format:          "text",
+			AccessFormat:    "combined",
+			AccessMaxSizeMB: 100,
+			AccessBackups:   5,
+		},
+	}
+}
+
+// Load builds the configuration for args. The file named by -config or
+// $FILESHARE_CONFIG is read first, then the environment, then the flags in
+// args. fs may already hold flags that are not settings; they are parsed
+// along with the rest. Relative paths, including the config file's, are
+// taken relative to baseDir.
+func Load(fs *flag.FlagSet, args []string, baseDir string) (*Config, error) {
+	c := Default()
+	path := configArg(args)
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		if err := c.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	fs.String("config", path, "Read settings from this YAML or TOML file (default $FILESHARE_CONFIG)")
+	c.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c.resolvePaths(baseDir)
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configArg finds -config in args before the flags are parsed, since the
+// file supplies the flag defaults.
+func configArg(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// LoadFile overlays the settings in a .yaml, .yml or .toml file. Unknown
+// keys are an error so typos do not go unnoticed.
+func (c *Config) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && err != io.EOF {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.NewDecoder(f).Decode(c)
+		if err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, k := range undecoded {
+				keys[i] = k.String()
+			}
+			return fmt.Errorf("config %s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("config %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// ApplyEnv overlays FILESHARE_* variables. Lists are comma separated.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	walk(reflect.ValueOf(c).Elem(), "", func(key string, v reflect.Value) {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		s, ok := lookup(name)
+		if !ok {
+			return
+		}
+		if err := setString(v, s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// RegisterFlags defines a flag for each setting, defaulting to the current
+// value so that flags override the file and environment.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Port, "port", c.Port, "The port to run the server on")
+	fs.StringVar(&c.Root, "root", c.Root, "Directory to share (default the current directory)")
+	fs.BoolVar(&c.ShowHidden, "show-hidden", c.ShowHidden, "Show dotfiles in listings, zips and search")
+	fs.Var((*stringList)(&c.Ignore), "ignore", "Hide paths matching this gitignore-style pattern (repeatable)")
+	fs.DurationVar((*time.Duration)(&c.ShutdownTimeout), "shutdown-timeout", time.Duration(c.ShutdownTimeout), "How long to let transfers finish after SIGINT or SIGTERM")
+
+	fs.Var((*stringList)(&c.Interfaces), "interface", "Advertise this interface or IP in the URL, QR code and mDNS (repeatable; default every non-virtual interface)")
+	fs.Var((*stringList)(&c.TrustedProxies), "trusted-proxy", "Believe X-Forwarded-For and X-Forwarded-Proto from this IP or CIDR (repeatable)")
+
+	fs.StringVar(&c.TLS.Mode, "tls", c.TLS.Mode, "Serve HTTPS: on, off (plain HTTP, e.g. behind a TLS proxy) or auto (on when the certificate exists)")
+	fs.StringVar(&c.TLS.RedirectPort, "redirect-port", c.TLS.RedirectPort, "Also listen for plain HTTP on this port and redirect it to HTTPS")
+	fs.BoolVar(&c.TLS.HTTP3, "http3", c.TLS.HTTP3, "Also serve HTTP/3 over QUIC on the same UDP port and advertise it with Alt-Svc")
+	fs.StringVar(&c.TLS.Cert, "cert", c.TLS.Cert, "TLS certificate (default cert.pem next to the binary)")
+	fs.StringVar(&c.TLS.Key, "key", c.TLS.Key, "TLS private key (default key.pem next to the binary)")
+
+	fs.StringVar(&c.Admin.User, "admin-user", c.Admin.User, "User name for the /admin page")
+	fs.StringVar(&c.Admin.Password, "admin-password", c.Admin.Password, "Enable /admin with this password")
+	fs.BoolVar(&c.Admin.LinksOnly, "links-only", c.Admin.LinksOnly, "Serve files only to visitors with a share link from /admin")
+
+	fs.TextVar(&c.Limits.Down, "limit-down", c.Limits.Down, "Cap total download bandwidth, e.g. 10M bytes/s (0 for unlimited)")
+	fs.TextVar(&c.Limits.Up, "limit-up", c.Limits.Up, "Cap total upload bandwidth, e.g. 10M bytes/s (0 for unlimited)")
+	fs.TextVar(&c.Limits.ClientDown, "limit-client-down", c.Limits.ClientDown, "Cap download bandwidth per client IP")
+	fs.TextVar(&c.Limits.ClientUp, "limit-client-up", c.Limits.ClientUp, "Cap upload bandwidth per client IP")
+
+	fs.IntVar(&c.Pools.UploadWorkers, "upload-workers", c.Pools.UploadWorkers, "Chunk writes that may run at once")
+	fs.IntVar(&c.Pools.UploadQueue, "upload-queue", c.Pools.UploadQueue, "Chunk writes that may wait for a worker")
+	fs.IntVar(&c.Pools.DownloadWorkers, "download-workers", c.Pools.DownloadWorkers, "Folder zips that may run at once")
+	fs.IntVar(&c.Pools.DownloadQueue, "download-queue", c.Pools.DownloadQueue, "Folder zips that may wait for a worker")
+	fs.IntVar(&c.Pools.ZipsPerClient, "zips-per-client", c.Pools.ZipsPerClient, "Most folder zips one client can have running at once (0 for no limit)")
+	fs.DurationVar((*time.Duration)(&c.Pools.ZipTimeout), "zip-timeout", time.Duration(c.Pools.ZipTimeout), "Abort folder zips that run longer than this (0 for no limit)")
+
+	fs.DurationVar((*time.Duration)(&c.Cleanup.MaxAge), "cleanup-max-age", time.Duration(c.Cleanup.MaxAge), "Delete unfinished uploads older than this")
+	fs.DurationVar((*time.Duration)(&c.Cleanup.Interval), "cleanup-interval", time.Duration(c.Cleanup.Interval), "How often to look for unfinished uploads")
+
+	fs.StringVar(&c.MDNS.Name, "mdns-name", c.MDNS.Name, "Host name to announce over mDNS, reachable as NAME.local (suffixed -2, -3... if taken)")
+	fs.StringVar(&c.MDNS.Instance, "mdns-instance", c.MDNS.Instance, "Service name shown when browsing the network (default the mDNS name)")
+
+	fs.StringVar(&c.QR.URL, "qr-url", c.QR.URL, "URL in the QR code: ip, mdns, admin (the admin page with credentials) or share (a revocable share link)")
+	fs.BoolVar(&c.QR.Invert, "qr-invert", c.QR.Invert, "Invert the terminal QR code for light backgrounds")
+	fs.BoolVar(&c.QR.Compact, "qr-compact", c.QR.Compact, "Draw the terminal QR code with a narrow border")
+	fs.StringVar(&c.QR.File, "qr-file", c.QR.File, "Also write the QR code to this .png or .svg file")
+	fs.BoolVar(&c.QR.Serve, "qr-serve", c.QR.Serve, "Serve the QR code at /qr (with -qr-url admin only over TLS, behind the admin login)")
+
+	fs.TextVar(&c.Upload.ChunkSize, "chunk-size", c.Upload.ChunkSize, "Size of each upload chunk sent by the browser")
+	fs.IntVar(&c.Upload.ParallelChunks, "parallel-chunks", c.Upload.ParallelChunks, "Chunks the browser uploads at once")
+
+	fs.StringVar(&c.Index.File, "index-file", c.Index.File, "Persist the file index to this path so restarts rescan incrementally")
+	fs.StringVar(&c.Index.ContentFile, "content-index", c.Index.ContentFile, "Enable full-text search of text files, storing the index at this path")
+	fs.TextVar(&c.Index.ContentMaxSize, "content-max-size", c.Index.ContentMaxSize, "Largest text file to include in the content index")
+
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "Log level: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "Log output format: text or json")
+	fs.StringVar(&c.Log.AccessFile, "access-log", c.Log.AccessFile, "Write an access log to this file")
+	fs.StringVar(&c.Log.AccessFormat, "access-log-format", c.Log.AccessFormat, "Access log format: common, combined or json")
+	fs.Int64Var(&c.Log.AccessMaxSizeMB, "access-log-max-size", c.Log.AccessMaxSizeMB, "Rotate the access log after this many megabytes")
+	fs.IntVar(&c.Log.AccessBackups, "access-log-backups", c.Log.AccessBackups, "Number of rotated access logs to keep")
+	fs.StringVar(&c.Log.AuditFile, "audit-log", c.Log.AuditFile, "Append a hash-chained audit trail to this file (viewable at /audit when an admin password is set)")
+}
+
+// resolvePaths makes relative file settings absolute so they keep working
+// after the server changes into Root.
+func (c *Config) resolvePaths(base string) {
+	for _, p := range []*string{&c.Root, &c.TLS.Cert, &c.TLS.Key, &c.Index.File, &c.Index.ContentFile, &c.Log.AccessFile, &c.Log.AuditFile, &c.QR.File} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(base, *p)
+		}
+	}
+}
+
+var hostLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// Validate reports every invalid setting at once, naming each by its key.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(validPort(c.Port), "port", "%q is not a port number between 1 and 65535", c.Port)
+	if c.Root != "" {
+		info, err := os.Stat(c.Root)
+		check(err == nil && info.IsDir(), "root", "%s is not a directory", c.Root)
+	}
+	check(c.ShutdownTimeout >= 0, "shutdown_timeout", "must not be negative")
+	for _, f := range []struct{ key, path string }{{"tls.cert", c.TLS.Cert}, {"tls.key", c.TLS.Key}} {
+		// In auto mode a missing certificate means plain HTTP.
+		if f.path != "" && c.TLS.Mode == "on" {
+			_, err := os.Stat(f.path)
+			check(err == nil, f.key, "%v", err)
+		}
+	}
+	switch c.TLS.Mode {
+	case "on", "off", "auto":
+	default:
+		check(false, "tls.mode", "%q is not on, off or auto", c.TLS.Mode)
+	}
+	if c.TLS.RedirectPort != "" {
+		check(validPort(c.TLS.RedirectPort), "tls.redirect_port", "%q is not a port number between 1 and 65535", c.TLS.RedirectPort)
+		check(c.TLS.RedirectPort != c.Port, "tls.redirect_port", "must differ from port")
+		check(c.TLS.Mode != "off", "tls.redirect_port", "needs tls.mode on or auto")
+	}
+	check(!c.TLS.HTTP3 || c.TLS.Mode != "off", "tls.http3", "needs tls.mode on or auto")
+	_, err := proxy.ParseTrusted(c.TrustedProxies)
+	check(err == nil, "trusted_proxies", "%v", err)
+	check(c.Admin.Password == "" || c.Admin.User != "", "admin.user", "must be set when admin.password is")
+	check(!c.Admin.LinksOnly || c.Admin.Password != "", "admin.links_only", "needs admin.password to make links")
+
+	check(c.Pools.UploadWorkers > 0, "pools.upload_workers", "must be at least 1")
+	check(c.Pools.UploadQueue > 0, "pools.upload_queue", "must be at least 1")
+	check(c.Pools.DownloadWorkers > 0, "pools.download_workers", "must be at least 1")
+	check(c.Pools.DownloadQueue > 0, "pools.download_queue", "must be at least 1")
+	check(c.Pools.ZipsPerClient >= 0, "pools.zips_per_client", "must not be negative")
+	check(c.Pools.ZipTimeout >= 0, "pools.zip_timeout", "must not be negative")
+
+	check(c.Cleanup.MaxAge > 0, "cleanup.max_age", "must be positive")
+	check(c.Cleanup.Interval > 0, "cleanup.interval", "must be positive")
+	check(len(c.MDNS.Instance) <= 60, "mdns.instance", "must be at most 60 characters")
+	check(hostLabel.MatchString(c.MDNS.Name), "mdns.name", "%q is not a valid host name (letters, digits and hyphens)", c.MDNS.Name)
+
+	switch c.QR.URL {
+	case "ip", "mdns", "share":
+	case "admin":
+		check(c.Admin.Password != "", "qr.url", "admin needs admin.password")
+		check(!c.QR.Serve || c.TLS.Mode != "off", "qr.serve", "with qr.url admin needs tls.mode on or auto")
+	default:
+		check(false, "qr.url", "%q is not ip, mdns, admin or share", c.QR.URL)
+	}
+	if c.QR.File != "" {
+		ext := strings.ToLower(filepath.Ext(c.QR.File))
+		check(ext == ".png" || ext == ".svg", "qr.file", "%s must end in .png or .svg", c.QR.File)
+	}
+
+	check(c.Upload.ChunkSize >= 64<<10 && c.Upload.ChunkSize <= 256<<20, "upload.chunk_size", "must be between 64K and 256M")
+	check(c.Upload.ParallelChunks >= 1 && c.Upload.ParallelChunks <= 16, "upload.parallel_chunks", "must be between 1 and 16")
+	check(c.Index.ContentMaxSize > 0, "index.content_max_size", "must be positive")
+
+	var lvl slog.Level
+	check(lvl.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level", "%q is not debug, info, warn or error", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format", "%q is not text or json", c.Log.Format)
+	switch c.Log.AccessFormat {
+	case "common", "combined", "json":
+	default:
+		check(false, "log.access_format", "%q is not common, combined or json", c.Log.AccessFormat)
+	}
+	check(c.Log.AccessMaxSizeMB >= 0, "log.access_max_size_mb", "must not be negative")
+	check(c.Log.AccessBackups >= 0, "log.access_backups", "must not be negative")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %w", joinIndented(errs))
+	}
+	return nil
+}
+
+func validPort(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port > 0 && port < 65536
+}
+
+func joinIndented(errs []error) error {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "\n  "))
+}
+
+// Diff lists the keys whose values differ between a and b.
+func Diff(a, b *Config) []string {
+	values := map[string]any{}
+	walk(reflect.ValueOf(a).Elem(), "", func(key string, v reflect.Value) {
+		values[key] = v.Interface()
+	})
+	var changed []string
+	walk(reflect.ValueOf(b).Elem(), "", func(key string, v reflect.Value) {
+		if !reflect.DeepEqual(values[key], v.Interface()) {
+			changed = append(changed, key)
+		}
+	})
+	return changed
+}
+
+// walk calls fn for every leaf setting with its dotted key.
+func walk(v reflect.Value, prefix string, fn func(key string, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		key := prefix + name
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct {
+			walk(f, key+".", fn)
+			continue
+		}
+		fn(key, f)
+	}
+}
+
+func setString(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// stringList collects a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
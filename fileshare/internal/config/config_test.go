@@ -0,0 +1,152 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load runs Load with a config file holding file (if not empty), the
+// environment variables in env and the command line args, from dir.
+func load(t *testing.T, dir, file string, env map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+	if file != "" {
+		name := "fileshare.yaml"
+		if strings.HasPrefix(file, "#toml\n") {
+			name = "fileshare.toml"
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", name}, args...)
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args, dir)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	const file = "port: \"9000\"\nlimits:\n  down: 1M\ncleanup:\n  max_age: 2h\n"
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		port     string
+		down     Size
+		maxAge   time.Duration
+		trusted  []string
+		showHide bool
+	}{
+		{name: "defaults", port: "8080", maxAge: 24 * time.Hour},
+		{name: "file over defaults", file: file, port: "9000", down: 1 << 20, maxAge: 2 * time.Hour},
+		{name: "env over file", file: file,
+			env:  map[string]string{"FILESHARE_PORT": "9100", "FILESHARE_LIMITS_DOWN": "2M"},
+			port: "9100", down: 2 << 20, maxAge: 2 * time.Hour},
+		{name: "flags over env", file: file,
+			env:  map[string]string{"FILESHARE_PORT": "9100", "FILESHARE_CLEANUP_MAX_AGE": "3h"},
+			args: []string{"-port", "9200", "-cleanup-max-age", "4h"},
+			port: "9200", down: 1 << 20, maxAge: 4 * time.Hour},
+		{name: "env lists split on commas",
+			env:  map[string]string{"FILESHARE_TRUSTED_PROXIES": "127.0.0.1, 10.0.0.0/8", "FILESHARE_SHOW_HIDDEN": "true"},
+			port: "8080", maxAge: 24 * time.Hour, trusted: []string{"127.0.0.1", "10.0.0.0/8"}, showHide: true},
+		{name: "flags add to lists",
+			env:  map[string]string{"FILESHARE_TRUSTED_PROXIES": "10.0.0.1"},
+			args: []string{"-trusted-proxy", "127.0.0.1"},
+			port: "8080", maxAge: 24 * time.Hour, trusted: []string{"10.0.0.1", "127.0.0.1"}},
+		{name: "toml", file: "#toml\nport = \"9300\"\n[limits]\ndown = \"3M\"\n",
+			port: "9300", down: 3 << 20, maxAge: 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := load(t, t.TempDir(), tt.file, tt.env, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Port != tt.port || c.Limits.Down != tt.down || time.Duration(c.Cleanup.MaxAge) != tt.maxAge {
+				t.Errorf("port %s, down %d, max age %v; want %s, %d, %v",
+					c.Port, c.Limits.Down, time.Duration(c.Cleanup.MaxAge), tt.port, tt.down, tt.maxAge)
+			}
+			if !slices.Equal(c.TrustedProxies, tt.trusted) || c.ShowHidden != tt.showHide {
+				t.Errorf("trusted proxies %q, show hidden %v; want %q, %v", c.TrustedProxies, c.ShowHidden, tt.trusted, tt.showHide)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("port: \"9400\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := load(t, dir, "", map[string]string{"FILESHARE_CONFIG": "other.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "9400" {
+		t.Errorf("port = %s, want 9400 from $FILESHARE_CONFIG", c.Port)
+	}
+}
+
+func TestLoadResolvesPaths(t *testing.T) {
+	dir := t.TempDir()
+	c, err := load(t, dir, "log:\n  audit_file: logs/audit.log\n", nil, "-index-file", "/var/idx.gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "logs", "audit.log"); c.Log.AuditFile != want {
+		t.Errorf("audit file = %s, want %s", c.Log.AuditFile, want)
+	}
+	if c.Index.File != "/var/idx.gob" {
+		t.Errorf("index file = %s, want it left absolute", c.Index.File)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want []string
+	}{
+		{name: "unknown yaml key", file: "prot: \"80\"\n", want: []string{"prot"}},
+		{name: "unknown toml key", file: "#toml\nprot = \"80\"\n", want: []string{"prot"}},
+		{name: "bad env value", env: map[string]string{"FILESHARE_LIMITS_UP": "fast"}, want: []string{"FILESHARE_LIMITS_UP"}},
+		{name: "every invalid key named", args: []string{"-port", "0", "-upload-workers", "0", "-qr-url", "admin"},
+			want: []string{"port:", "pools.upload_workers:", "qr.url: admin needs admin.password"}},
+		{name: "unknown qr url", args: []string{"-qr-url", "token"}, want: []string{`qr.url: "token" is not ip, mdns, admin or share`}},
+		{name: "links only needs admin", args: []string{"-links-only"}, want: []string{"admin.links_only:"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, t.TempDir(), tt.file, tt.env, tt.args...)
+			if err == nil {
+				t.Fatal("loaded without error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, b := Default(), Default()
+	b.Port = "9000"
+	b.Limits.Down = 1 << 20
+	b.Ignore = []string{"*.iso"}
+	if got, want := Diff(a, b), []string{"port", "ignore", "limits.down"}; !slices.Equal(got, want) {
+		t.Errorf("Diff = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fileshare/internal/ratelimit"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration written as "90s" or "24h" in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (want e.g. 30s, 10m or 24h)", text)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Size is a byte count written as "512K", "4M" or "1G" in config files.
+type Size int64
+
+func (s *Size) UnmarshalText(text []byte) error {
+	v, err := ratelimit.ParseRate(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid size %q (want e.g. 512K, 4M or 1G)", text)
+	}
+	*s = Size(v)
+	return nil
+}
+
+func (s Size) MarshalText() ([]byte, error) {
+	if s == 0 {
+		return []byte("0"), nil
+	}
+	return []byte(ratelimit.FormatRate(int64(s))), nil
+}
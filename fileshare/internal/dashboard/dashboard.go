@@ -0,0 +1,286 @@
+// Package dashboard
+package dashboard
+
+import (
+	"fileshare/internal/activity"
+	"fileshare/internal/worker"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+const kickDuration = 10 * time.Minute
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Dashboard is a full-screen terminal view of live server activity.
+type Dashboard struct {
+	Tracker      *activity.Tracker
+	DownloadPool *worker.Pool
+	URL          string
+	Root         string
+	// Quit closes the dashboard when the server is shutting down.
+	Quit <-chan struct{}
+
+	selected int
+}
+
+// Run takes over the terminal until the user presses q or Ctrl-C. It returns
+// true when the user asked to stop the server.
+func (d *Dashboard) Run() (quitServer bool, err error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return false, fmt.Errorf("stdin is not a terminal")
+	}
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return false, err
+	}
+	// Alternate screen, hidden cursor; restored on the way out.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		term.Restore(fd, oldState)
+	}()
+
+	keys := make(chan byte, 16)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			for _, b := range buf[:n] {
+				keys <- b
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	var escSeq []byte
+	for {
+		d.render()
+		select {
+		case <-ticker.C:
+		case <-d.Quit:
+			return false, nil
+		case b, ok := <-keys:
+			if !ok {
+				return false, nil
+			}
+			// Arrow keys arrive as ESC [ A / ESC [ B.
+			if b == 0x1b || len(escSeq) > 0 {
+				escSeq = append(escSeq, b)
+				if len(escSeq) == 3 {
+					switch escSeq[2] {
+					case 'A':
+						d.selected--
+					case 'B':
+						d.selected++
+					}
+					escSeq = nil
+				}
+				continue
+			}
+			switch b {
+			case 'q':
+				return false, nil
+			case 3: // Ctrl-C
+				return true, nil
+			case 'p':
+				d.Tracker.SetUploadsPaused(!d.Tracker.UploadsPaused())
+			case 'k':
+				clients := d.Tracker.Snapshot().Clients
+				if d.selected >= 0 && d.selected < len(clients) {
+					d.Tracker.Kick(clients[d.selected].Addr, kickDuration)
+				}
+			}
+		}
+	}
+}
+
+func (d *Dashboard) render() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 100, 40
+	}
+	s := d.Tracker.Snapshot()
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	bold := func(text string) string { return "\x1b[1m" + text + "\x1b[0m" }
+
+	uploads := "\x1b[32mRUNNING\x1b[0m"
+	if s.UploadsPaused {
+		uploads = "\x1b[31mPAUSED\x1b[0m"
+	}
+	add("%s  %s  up %s  uploads %s", bold("FileShare"), d.URL, time.Since(s.Started).Round(time.Second), uploads)
+	add("Sharing %s", d.Root)
+	add("")
+
+	var in, out []int64
+	for _, smp := range s.Throughput {
+		in = append(in, smp.In)
+		out = append(out, smp.Out)
+	}
+	graphWidth := max(width-30, 10)
+	add("%s  %s %s/s", padRight("In ", 4), sparkline(in, graphWidth), formatBytes(last(in)))
+	add("%s  %s %s/s", padRight("Out", 4), sparkline(out, graphWidth), formatBytes(last(out)))
+	add("")
+
+	add("%s", bold(fmt.Sprintf("Clients (%d)", len(s.Clients))))
+	if d.selected >= len(s.Clients) {
+		d.selected = len(s.Clients) - 1
+	}
+	if d.selected < 0 {
+		d.selected = 0
+	}
+	for i, c := range s.Clients {
+		marker := "  "
+		if i == d.selected {
+			marker = "> "
+		}
+		state := ""
+		if c.Kicked {
+			state = " [kicked]"
+		}
+		add("%s%-40s active %-3d reqs %-6d in %-10s out %-10s seen %s ago%s", marker, c.Addr, c.Active, c.Requests,
+			formatBytes(c.BytesIn), formatBytes(c.BytesOut), time.Since(c.LastSeen).Round(time.Second), state)
+	}
+	add("")
+
+	add("%s", bold(fmt.Sprintf("Uploads (%d)", len(s.Uploads))))
+	for _, u := range s.Uploads {
+		progress := formatBytes(u.Received)
+		bar := ""
+		if u.Size > 0 {
+			pct := float64(u.Received) / float64(u.Size)
+			bar = progressBar(pct, 20) + fmt.Sprintf(" %3.0f%% ", pct*100)
+			progress += " / " + formatBytes(u.Size)
+		}
+		add("  %s%s  %s  from %s", bar, u.Path, progress, u.Client)
+	}
+	add("")
+
+	var zips []activity.RequestInfo
+	for _, req := range s.Requests {
+		if req.Path == "/zip" {
+			zips = append(zips, req)
+		}
+	}
+	queued := 0
+	if d.DownloadPool != nil {
+		queued = d.DownloadPool.QueueLength()
+	}
+	add("%s", bold(fmt.Sprintf("Zip jobs (%d active, %d queued)", len(zips), queued)))
+	for _, z := range zips {
+		add("  %s  %s sent  %s  for %s", strings.TrimPrefix(z.Query, "path="), formatBytes(z.BytesOut),
+			time.Since(z.Started).Round(time.Second), z.Client)
+	}
+	add("")
+
+	add("%s", bold("Recent events"))
+	footer := "↑/↓ select client   k kick client   p pause/resume uploads   q close dashboard   Ctrl-C stop server"
+	room := height - len(lines) - 2
+	events := s.Events
+	if room < len(events) {
+		events = events[max(len(events)-room, 0):]
+	}
+	for _, e := range events {
+		add("  %s %s", e.Time.Format("15:04:05"), e.Message)
+	}
+
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:height-1], "\x1b[7m"+padRight(footer, width)+"\x1b[0m")
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		b.WriteString(truncate(line, width))
+		b.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	fmt.Print(b.String())
+}
+
+func sparkline(values []int64, width int) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	var peak int64 = 1
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for i := len(values); i < width; i++ {
+		b.WriteRune(' ')
+	}
+	for _, v := range values {
+		b.WriteRune(sparks[int(v*int64(len(sparks)-1)/peak)])
+	}
+	return b.String()
+}
+
+func progressBar(pct float64, width int) string {
+	filled := min(int(pct*float64(width)), width)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
+func last(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	return values[len(values)-1]
+}
+
+func padRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// truncate cuts a line to width visible runes, skipping ANSI escapes.
+func truncate(s string, width int) string {
+	visible, inEscape := 0, false
+	for i, r := range s {
+		if r == '\x1b' {
+			inEscape = true
+			continue
+		}
+		if inEscape {
+			inEscape = !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			continue
+		}
+		visible++
+		if visible > width {
+			return s[:i] + "\x1b[0m"
+		}
+	}
+	return s
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fileshare/internal/activity"
+	"fileshare/internal/audit"
+	"fileshare/internal/cleanup"
+	"fileshare/internal/logging"
+	"fileshare/internal/proxy"
+	"fileshare/internal/ratelimit"
+	"fileshare/internal/share"
+	"fileshare/internal/storage"
+	"fileshare/internal/templates"
+	"fileshare/internal/worker"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sessionIdle is how long a client counts as connected after its last request.
+	sessionIdle = 5 * time.Minute
+	// partialGrace protects recently written partials from "Remove all".
+	partialGrace = time.Minute
+)
+
+// AdminConfig describes what the admin page reports on and acts upon.
+type AdminConfig struct {
+	// Root is the shared directory as shown on the page and measured for
+	// free space; Cleanup lists and removes the partial uploads in it.
+	Root         string
+	Started      time.Time
+	UploadPool   *worker.Pool
+	DownloadPool *worker.Pool
+	Cleanup      *cleanup.Routine
+	Limiter      *ratelimit.Limiter
+	AuditLog     *audit.Log
+	Links        *share.Links
+	// LinksOnly is set when files are served only to share link holders.
+	LinksOnly bool
+}
+
+type poolStats struct {
+	Name     string `json:"name"`
+	Workers  int    `json:"workers"`
+	Busy     int    `json:"busy"`
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Percent  int    `json:"percent"`
+}
+
+type transfer struct {
+	activity.RequestInfo
+	Elapsed string `json:"elapsed"`
+	In      string `json:"in"`
+	Out     string `json:"out"`
+}
+
+type upload struct {
+	activity.Upload
+	Progress string `json:"progress"`
+}
+
+type session struct {
+	activity.ClientInfo
+	Idle string `json:"idle"`
+	In   string `json:"in"`
+	Out  string `json:"out"`
+}
+
+type partial struct {
+	cleanup.Partial
+	SizeText string `json:"sizeText"`
+	Age      string `json:"age"`
+}
+
+type shareLink struct {
+	share.Link
+	URL         string `json:"url"`
+	ExpiresText string `json:"expiresText"`
+}
+
+type adminPage struct {
+	Uptime        string               `json:"uptime"`
+	Root          string               `json:"root"`
+	Disk          *diskStats           `json:"disk,omitempty"`
+	UploadsPaused bool                 `json:"uploadsPaused"`
+	Sessions      []session            `json:"sessions"`
+	Transfers     []transfer           `json:"transfers"`
+	Uploads       []upload             `json:"uploads"`
+	Pools         []poolStats          `json:"pools"`
+	Errors        []logging.ErrorEntry `json:"errors"`
+	Partials      []partial            `json:"partials"`
+	PartialsAge   string               `json:"partialsAge"`
+	ShareLinks    []shareLink          `json:"shareLinks"`
+	LinksOnly     bool                 `json:"linksOnly"`
+	PartialMaxAge string               `json:"partialMaxAge"`
+	Bandwidth     *bandwidth           `json:"bandwidth,omitempty"`
+	Message       string               `json:"message,omitempty"`
+}
+
+// bandwidth shows the limits in the same notation the form accepts.
+type bandwidth struct {
+	Enabled    bool   `json:"enabled"`
+	Up         string `json:"up"`
+	Down       string `json:"down"`
+	ClientUp   string `json:"clientUp"`
+	ClientDown string `json:"clientDown"`
+}
+
+type diskStats struct {
+	Total   string `json:"total"`
+	Used    string `json:"used"`
+	Free    string `json:"free"`
+	Percent int    `json:"percent"`
+}
+
+// RequireAdmin wraps next with HTTP Basic authentication against a single
+// admin account.
+func RequireAdmin(user, password string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOK || !passOK {
+			if ok {
+				logging.FromContext(r.Context()).Warn("Admin login failed", "user", u, "client", activity.ClientAddr(r))
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="fileshare admin", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, activity.WithUser(r, u))
+	}
+}
+
+// AdminHandler serves the admin overview at /admin and its actions at
+// /admin/cancel, /admin/kick, /admin/pause, /admin/cleanup, /admin/limits,
+// /admin/share and /admin/revoke.
+func AdminHandler(cfg AdminConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tracker := activity.FromContext(r.Context())
+		if r.URL.Path == "/admin" || r.URL.Path == "/admin/" {
+			renderAdmin(w, r, cfg, tracker)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		var msg string
+		switch r.URL.Path {
+		case "/admin/cancel":
+			id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+			if tracker.Cancel(id) {
+				msg = fmt.Sprintf("Cancelled request %d", id)
+			} else {
+				msg = fmt.Sprintf("Request %d already finished", id)
+			}
+		case "/admin/kick":
+			client := r.FormValue("client")
+			tracker.Kick(client, 10*time.Minute)
+			msg = "Disconnected " + client + " for 10 minutes"
+		case "/admin/pause":
+			paused := r.FormValue("paused") == "1"
+			tracker.SetUploadsPaused(paused)
+			msg = "Uploads resumed"
+			if paused {
+				msg = "Uploads paused"
+			}
+		case "/admin/cleanup":
+			maxAge := cfg.Cleanup.MaxAge()
+			if r.FormValue("all") == "1" {
+				// A partial written to moments ago may have a chunk in
+				// flight that the tracker has not seen yet.
+				maxAge = partialGrace
+			}
+			// Deleting a partial mid-upload would let the next chunk
+			// recreate it with a zero-filled hole, so uploads still in
+			// progress are skipped.
+			active := map[string]bool{}
+			for _, u := range tracker.Snapshot().Uploads {
+				active[cleanup.PartialName(storage.Name(u.Path))] = true
+			}
+			removed := cfg.Cleanup.Clean(maxAge, func(name string) bool { return active[name] })
+			for _, p := range removed {
+				cfg.AuditLog.Record(r, audit.ActionDelete, "/"+p.Path, p.Size, "")
+			}
+			msg = fmt.Sprintf("Removed %d partial uploads", len(removed))
+		case "/admin/share":
+			var ttl time.Duration
+			if v := r.FormValue("ttl"); v != "" {
+				d, err := time.ParseDuration(v)
+				if err != nil || d < 0 {
+					http.Error(w, "Invalid expiry: "+v, http.StatusBadRequest)
+					return
+				}
+				ttl = d
+			}
+			link := cfg.Links.Create(r.FormValue("path"), ttl)
+			cfg.AuditLog.Record(r, audit.ActionShare, link.Path, 0, "")
+			msg = "Created share link for " + link.Path
+		case "/admin/revoke":
+			link, err := cfg.Links.Revoke(r.FormValue("token"))
+			if err != nil {
+				msg = "Link was already revoked or expired"
+				break
+			}
+			cfg.AuditLog.Record(r, audit.ActionRevoke, link.Path, 0, "")
+			msg = "Revoked share link for " + link.Path
+		case "/admin/limits":
+			if cfg.Limiter == nil {
+				http.NotFound(w, r)
+				return
+			}
+			var limits ratelimit.Limits
+			for _, f := range []struct {
+				name string
+				dst  *int64
+			}{{"up", &limits.Up}, {"down", &limits.Down}, {"clientUp", &limits.ClientUp}, {"clientDown", &limits.ClientDown}} {
+				v, err := ratelimit.ParseRate(r.FormValue(f.name))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				*f.dst = v
+			}
+			cfg.Limiter.Set(limits)
+			cfg.Limiter.SetEnabled(r.FormValue("enabled") == "1")
+			msg = "Bandwidth limits updated"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		logger.Info("Admin action", "action", strings.TrimPrefix(r.URL.Path, "/admin/"), "result", msg)
+		http.Redirect(w, r, "/admin?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+	}
+}
+
+func renderAdmin(w http.ResponseWriter, r *http.Request, cfg AdminConfig, tracker *activity.Tracker) {
+	logger := logging.FromContext(r.Context())
+	now := time.Now()
+	snap := tracker.Snapshot()
+
+	page := adminPage{
+		Uptime:        now.Sub(cfg.Started).Round(time.Second).String(),
+		Root:          cfg.Root,
+		UploadsPaused: snap.UploadsPaused,
+		Errors:        logging.RecentErrors(),
+		PartialMaxAge: cfg.Cleanup.MaxAge().String(),
+		LinksOnly:     cfg.LinksOnly,
+		Message:       r.URL.Query().Get("msg"),
+	}
+	if cfg.Limiter != nil {
+		limits := cfg.Limiter.Limits()
+		page.Bandwidth = &bandwidth{
+			Enabled:    cfg.Limiter.Enabled(),
+			Up:         ratelimit.FormatRate(limits.Up),
+			Down:       ratelimit.FormatRate(limits.Down),
+			ClientUp:   ratelimit.FormatRate(limits.ClientUp),
+			ClientDown: ratelimit.FormatRate(limits.ClientDown),
+		}
+	}
+	if total, free, err := diskUsage(cfg.Root); err == nil && total > 0 {
+		used := total - free
+		page.Disk = &diskStats{
+			Total:   formatSize(int64(total)),
+			Used:    formatSize(int64(used)),
+			Free:    formatSize(int64(free)),
+			Percent: int(used * 100 / total),
+		}
+	}
+
+	for _, c := range snap.Clients {
+		if c.Active == 0 && now.Sub(c.LastSeen) > sessionIdle {
+			continue
+		}
+		page.Sessions = append(page.Sessions, session{c, now.Sub(c.LastSeen).Round(time.Second).String(),
+			formatSize(c.BytesIn), formatSize(c.BytesOut)})
+	}
+	for _, req := range snap.Requests {
+		if strings.HasPrefix(req.Path, "/admin") {
+			continue
+		}
+		page.Transfers = append(page.Transfers, transfer{req, now.Sub(req.Started).Round(time.Second).String(),
+			formatSize(req.BytesIn), formatSize(req.BytesOut)})
+	}
+	for _, u := range snap.Uploads {
+		progress := formatSize(u.Received)
+		if u.Size > 0 {
+			progress += fmt.Sprintf(" / %s (%d%%)", formatSize(u.Size), u.Received*100/u.Size)
+		}
+		page.Uploads = append(page.Uploads, upload{u, progress})
+	}
+	for _, p := range []struct {
+		name string
+		pool *worker.Pool
+	}{{"upload", cfg.UploadPool}, {"download", cfg.DownloadPool}} {
+		if p.pool == nil {
+			continue
+		}
+		stats := poolStats{Name: p.name, Workers: p.pool.WorkerCount, Busy: p.pool.Active(),
+			Queued: p.pool.QueueLength(), Capacity: p.pool.QueueCapacity()}
+		if stats.Capacity > 0 {
+			stats.Percent = stats.Queued * 100 / stats.Capacity
+		}
+		page.Pools = append(page.Pools, stats)
+	}
+	// The list comes from the last cleanup sweep rather than a walk of the
+	// whole share on every page load.
+	partials, listed := cfg.Cleanup.Partials()
+	page.PartialsAge = now.Sub(listed).Round(time.Second).String()
+	for _, p := range partials {
+		page.Partials = append(page.Partials, partial{p, formatSize(p.Size), now.Sub(p.ModTime).Round(time.Second).String()})
+	}
+
+	for _, l := range cfg.Links.List() {
+		expires := "never"
+		if !l.Expires.IsZero() {
+			expires = "in " + l.Expires.Sub(now).Round(time.Second).String()
+		}
+		page.ShareLinks = append(page.ShareLinks, shareLink{l, shareURL(r, l), expires})
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, page)
+		return
+	}
+
+	t, err := template.New("admin").Parse(templates.AdminTpl)
+	if err != nil {
+		logger.Error("Template parse error", "err", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	if err := t.Execute(w, page); err != nil {
+		logger.Error("Template execution error", "err", err)
+	}
+}
+
+// shareURL is the address of link on the host the admin is using.
+func shareURL(r *http.Request, link share.Link) string {
+	return proxy.Scheme(r) + "://" + r.Host + "/s/" + link.Token
+}
+
+// sameOrigin rejects form posts from other sites. Browsers attach cached
+// Basic credentials to cross-site requests, so authentication alone is not
+// enough for state-changing actions. The scheme is compared as the client
+// sees it, behind a trusted proxy too.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host && u.Scheme == proxy.Scheme(r)
+}
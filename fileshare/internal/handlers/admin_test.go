@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fileshare/internal/activity"
+	"fileshare/internal/audit"
+	"fileshare/internal/cleanup"
+	"fileshare/internal/share"
+	"fileshare/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestAdminCleanup(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	store := storage.NewMemory(fstest.MapFS{
+		"docs/a.txt":               {Data: []byte("hello")},
+		"docs/.stale.bin.partial":  {Data: []byte("x"), ModTime: old},
+		"docs/.active.bin.partial": {Data: []byte("x"), ModTime: old},
+		"docs/.fresh.bin.partial":  {Data: []byte("x"), ModTime: time.Now()},
+	})
+	tracker := activity.New()
+	tracker.UploadChunk(httptest.NewRequest(http.MethodPost, "/upload", nil), "u1", "/docs/active.bin", 2, 1)
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	handler := tracker.Middleware(AdminHandler(AdminConfig{
+		Cleanup:  cleanup.StartCleanupRoutine(store, 24*time.Hour, time.Hour),
+		AuditLog: auditLog,
+	}))
+
+	tests := []struct {
+		form    url.Values
+		message string
+		left    []string
+	}{
+		// Nothing is older than the 24h policy.
+		{url.Values{}, "Removed 0 partial uploads",
+			[]string{"docs/.active.bin.partial", "docs/.fresh.bin.partial", "docs/.stale.bin.partial"}},
+		// "Remove all idle" spares the tracked upload and the fresh partial.
+		{url.Values{"all": {"1"}}, "Removed 1 partial uploads",
+			[]string{"docs/.active.bin.partial", "docs/.fresh.bin.partial"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/admin/cleanup", strings.NewReader(tt.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = activity.WithUser(req, "admin")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("cleanup %v: status = %d, body %q", tt.form, rec.Code, rec.Body)
+		}
+		if want := "/admin?msg=" + url.QueryEscape(tt.message); rec.Header().Get("Location") != want {
+			t.Errorf("cleanup %v: redirect to %q, want %q", tt.form, rec.Header().Get("Location"), want)
+		}
+		var left []string
+		for _, p := range cleanup.ListPartials(store) {
+			left = append(left, p.Path)
+		}
+		slices.Sort(left)
+		if !slices.Equal(left, tt.left) {
+			t.Errorf("cleanup %v: left %q, want %q", tt.form, left, tt.left)
+		}
+	}
+
+	// The page lists what the last cleanup left without walking the share.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin?format=json", nil))
+	var page adminPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, p := range page.Partials {
+		listed = append(listed, p.Path)
+	}
+	slices.Sort(listed)
+	if want := tests[len(tests)-1].left; !slices.Equal(listed, want) {
+		t.Errorf("page lists %q, want %q", listed, want)
+	}
+
+	// Every removed partial is in the audit trail under the admin's name.
+	records, err := auditLog.Tail(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("%d audit records, want 1: %+v", len(records), records)
+	}
+	if rec := records[0]; rec.Action != audit.ActionDelete || rec.Path != "/docs/.stale.bin.partial" || rec.Identity != "admin" || rec.Bytes != 1 {
+		t.Errorf("audit record = %+v", rec)
+	}
+}
+
+func TestAdminCleanupRefusesCrossOrigin(t *testing.T) {
+	store := storage.NewMemory(fstest.MapFS{
+		".stale.bin.partial": {Data: []byte("x"), ModTime: time.Now().Add(-48 * time.Hour)},
+	})
+	handler := AdminHandler(AdminConfig{Cleanup: cleanup.StartCleanupRoutine(store, 72*time.Hour, time.Hour)})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/cleanup", strings.NewReader("all=1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+	if n := len(cleanup.ListPartials(store)); n != 1 {
+		t.Errorf("%d partials left, want 1", n)
+	}
+}
+
+func TestAdminShareLinks(t *testing.T) {
+	store := storage.NewMemory(fstest.MapFS{})
+	links := share.New()
+	handler := AdminHandler(AdminConfig{Cleanup: cleanup.StartCleanupRoutine(store, time.Hour, time.Hour), Links: links})
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := post("/admin/share", url.Values{"path": {"/docs"}, "ttl": {"soon"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad expiry: status = %d, want 400", rec.Code)
+	}
+	if rec := post("/admin/share", url.Values{"path": {"/docs"}, "ttl": {"1h"}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("share: status = %d, body %q", rec.Code, rec.Body)
+	}
+	list := links.List()
+	if len(list) != 1 || list[0].Path != "/docs" || list[0].Expires.IsZero() {
+		t.Fatalf("links = %+v, want one expiring link to /docs", list)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if !strings.Contains(rec.Body.String(), "/s/"+list[0].Token) {
+		t.Error("admin page does not show the link")
+	}
+
+	post("/admin/revoke", url.Values{"token": {list[0].Token}})
+	if list := links.List(); len(list) != 0 {
+		t.Errorf("links after revoke = %+v", list)
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fileshare/internal/audit"
+	"fileshare/internal/logging"
+	"fileshare/internal/templates"
+	"html/template"
+	"net/http"
+)
+
+// AuditHandler shows the newest audit records and whether the chain verifies.
+func AuditHandler(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+		records, err := auditLog.Tail(parseLimit(r, defaultResultLimit))
+		if err != nil {
+			logger.Error("Audit read failed", "err", err)
+		}
+
+		data := struct {
+			Records  []audit.Record
+			Verified int64
+			Error    string
+		}{Records: records}
+		data.Verified, err = auditLog.Verify()
+		if err != nil {
+			data.Error = err.Error()
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			writeJSON(w, data)
+			return
+		}
+
+		t, err := template.New("audit").Parse(templates.AuditTpl)
+		if err != nil {
+			logger.Error("Template parse error", "err", err)
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+		if err := t.Execute(w, data); err != nil {
+			logger.Error("Template execution error", "err", err)
+		}
+	}
+}
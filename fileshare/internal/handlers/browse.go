@@ -1,23 +1,45 @@
 package handlers
 
 import (
+	"fileshare/internal/audit"
+	"fileshare/internal/ignore"
+	"fileshare/internal/index"
+	"fileshare/internal/logging"
+	"fileshare/internal/metrics"
+	"fileshare/internal/ratelimit"
+	"fileshare/internal/storage"
 	"fileshare/internal/templates"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type FileItem struct {
-	Name string
-	Path string
-	IsDir bool
-	Size string
-	DownloadURL string
+	Name string `json:"name"`
+	Path string `json:"path"`
+	IsDir bool `json:"isDir"`
+	Size string `json:"sizeText"`
+	Bytes int64 `json:"size"`
+	Files int `json:"files,omitempty"`
+	SizePending bool `json:"sizePending,omitempty"`
+	ModTime time.Time `json:"modTime"`
+	Modified string `json:"-"`
+	DownloadURL string `json:"downloadUrl"`
+}
+
+// browsePage is the data rendered by BrowseTpl for listings and search results.
+type browsePage struct {
+	BreadCrumbs []BreadCrumb
+	Files []FileItem
+	CurrentPath string
+	Live bool
+	Query string
+	Hits []ContentResult
+	Options ListOptions
 }
 
 type BreadCrumb struct {
@@ -25,25 +47,31 @@ type BreadCrumb struct {
 	Link string
 }
 
-func FileServerHandler(baseDir string) http.HandlerFunc {
+// FileServerHandler serves files and directory listings. Folder sizes come
+// from idx once its initial scan is done; ?format=json returns the listing.
+// Paths excluded by m are neither listed nor served.
+func FileServerHandler(store storage.FS, idx *index.Index, m *ignore.Matcher, auditLog *audit.Log, limiter *ratelimit.Limiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[%s] %s %s", r.RemoteAddr, r.Method, r.URL.Path)
-
 		cleanPath := filepath.Clean(r.URL.Path)
-		fullPath := filepath.Join(baseDir, cleanPath)
+		name := storage.Name(cleanPath)
 
-		info, err := os.Stat(fullPath)
-		if err != nil {
+		info, err := store.Stat(name)
+		if err != nil || m.Ignored(cleanPath, info.IsDir()) {
 			http.NotFound(w, r)
 			return
 		}
 
 		if !info.IsDir() {
-			http.ServeFile(w, r, fullPath)
+			cw := &metrics.CountingWriter{ResponseWriter: limiter.Writer(r, w)}
+			http.ServeFileFS(cw, r, store, name)
+			metrics.BytesDownloaded.Add(float64(cw.Written))
+			if cw.Written > 0 {
+				auditLog.Record(r, audit.ActionDownload, filepath.ToSlash(cleanPath), cw.Written, "")
+			}
 			return
 		}
 
-		entries, err := os.ReadDir(fullPath)
+		entries, err := store.ReadDir(name)
 		if err != nil {
 			http.Error(w, "Could not read directory", http.StatusInternalServerError)
 			return
@@ -71,14 +99,20 @@ func FileServerHandler(baseDir string) http.HandlerFunc {
 
 		var items []FileItem
 		for _, entry := range entries {
-			if strings.HasPrefix(entry.Name(), ".") || strings.HasSuffix(entry.Name(), ".partial"){
+			if m.IgnoredName(r.URL.Path, entry.Name(), entry.IsDir()) {
 				continue
 			}
 
 			size := ""
+			var bytes int64
+			var modTime time.Time
 			info, err := entry.Info()
-			if err == nil && !entry.IsDir() {
-				size = formatSize(info.Size())
+			if err == nil {
+				modTime = info.ModTime()
+				if !entry.IsDir() {
+					bytes = info.Size()
+					size = formatSize(bytes)
+				}
 			}
 
 			currentURLPath := filepath.Join(r.URL.Path, entry.Name())
@@ -89,8 +123,15 @@ func FileServerHandler(baseDir string) http.HandlerFunc {
 			}
 
 			downloadURL := currentURLPath
+			files, pending := 0, false
 			if entry.IsDir() {
 				downloadURL = fmt.Sprintf("/zip?path=%s", currentURLPath)
+				if dir, ok := idx.Stat(currentURLPath); ok && idx.Ready() {
+					bytes, files = dir.Size, dir.Files
+					size = formatSize(bytes)
+				} else if idx.Enabled() {
+					size, pending = "Calculating…", true
+				}
 			}
 
 			items = append(items, FileItem{
@@ -98,29 +139,37 @@ func FileServerHandler(baseDir string) http.HandlerFunc {
 				Path: currentURLPath,
 				IsDir: entry.IsDir(),
 				Size: size,
+				Bytes: bytes,
+				Files: files,
+				SizePending: pending,
+				ModTime: modTime,
+				Modified: formatModTime(modTime),
 				DownloadURL: downloadURL,
 			})
 		}
 
-		data := struct {
-			BreadCrumbs []BreadCrumb
-			Files []FileItem
-			CurrentPath string
-		}{
+		opts := parseListOptions(w, r)
+		if r.URL.Query().Get("format") == "json" {
+			writeJSON(w, opts.Apply(items))
+			return
+		}
+		data := browsePage{
 			BreadCrumbs: breadcrumbs,
-			Files: items,
+			Files: opts.Apply(items),
 			CurrentPath: r.URL.Path,
+			Live: true,
+			Options: opts,
 		}
 
 		t, err := template.New("webpage").Parse(templates.BrowseTpl)
 		if err != nil {
-			log.Printf("[ERROR] Template Parse error: %v", err)
+			logging.FromContext(r.Context()).Error("Template parse error", "err", err)
 			http.Error(w, "Template error", 500)
 			return
 		}
-		 if err := t.Execute(w, data); err != nil {
-			 log.Printf("[ERROR] Template execution error: %v", err)
-		 }
+		if err := t.Execute(w, data); err != nil {
+			logging.FromContext(r.Context()).Error("Template execution error", "err", err)
+		}
 
 	}
 }
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fileshare/internal/ignore"
+	"fileshare/internal/index"
+	"fileshare/internal/storage"
+	"fileshare/internal/worker"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestStore returns a share with a visible folder, a dotfile and a file
+// hidden by the "*.log" rule of newTestMatcher.
+func newTestStore() *storage.Memory {
+	return storage.NewMemory(fstest.MapFS{
+		"top.txt":        {Data: []byte("top")},
+		"docs/a.txt":     {Data: []byte("hello")},
+		"docs/sub/b.txt": {Data: []byte("nested")},
+		"docs/.secret":   {Data: []byte("hidden")},
+		"docs/debug.log": {Data: []byte("ignored")},
+	})
+}
+
+func newTestMatcher(t *testing.T) *ignore.Matcher {
+	t.Helper()
+	m, err := ignore.New(t.TempDir(), []string{"*.log"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func newTestPool(t *testing.T, workers int) *worker.Pool {
+	t.Helper()
+	pool := worker.NewPool(workers, 10)
+	pool.Start()
+	t.Cleanup(pool.Stop)
+	return pool
+}
+
+func TestFileServerListsFolder(t *testing.T) {
+	m := newTestMatcher(t)
+	handler := FileServerHandler(newTestStore(), index.New(t.TempDir(), "", m), m, nil, nil)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/docs?format=json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var items []FileItem
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]FileItem{}
+	var names []string
+	for _, item := range items {
+		byName[item.Name] = item
+		names = append(names, item.Name)
+	}
+	slices.Sort(names)
+	if want := []string{"a.txt", "sub"}; !slices.Equal(names, want) {
+		t.Fatalf("listed %q, want %q", names, want)
+	}
+	if a := byName["a.txt"]; a.Bytes != 5 || a.DownloadURL != "/docs/a.txt" {
+		t.Errorf("a.txt = %+v", a)
+	}
+	// Without a running index folder sizes are left blank.
+	if sub := byName["sub"]; !sub.IsDir || sub.DownloadURL != "/zip?path=/docs/sub" || sub.Size != "" || sub.SizePending {
+		t.Errorf("sub = %+v", sub)
+	}
+}
+
+func TestFileServerServesFiles(t *testing.T) {
+	m := newTestMatcher(t)
+	handler := FileServerHandler(newTestStore(), index.New(t.TempDir(), "", m), m, nil, nil)
+
+	tests := []struct {
+		path   string
+		rng    string
+		status int
+		body   string
+	}{
+		{path: "/docs/a.txt", status: http.StatusOK, body: "hello"},
+		{path: "/docs/a.txt", rng: "bytes=1-3", status: http.StatusPartialContent, body: "ell"},
+		{path: "/docs/.secret", status: http.StatusNotFound},
+		{path: "/docs/debug.log", status: http.StatusNotFound},
+		{path: "/docs/missing.txt", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.rng != "" {
+			req.Header.Set("Range", tt.rng)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("GET %s (%s): status = %d, want %d", tt.path, tt.rng, rec.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("GET %s (%s): body = %q, want %q", tt.path, tt.rng, rec.Body, tt.body)
+		}
+	}
+}
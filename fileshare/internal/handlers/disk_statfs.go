@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package handlers
+
+import "syscall"
+
+// diskUsage reports the size and free space of the filesystem holding path.
+func diskUsage(path string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fileshare/internal/activity"
+	"fileshare/internal/ignore"
+	"fileshare/internal/index"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	eventsInterval  = 500 * time.Millisecond
+	eventsKeepalive = 15 * time.Second
+)
+
+// pendingUpload is an "uploading…" placeholder for the browse page.
+type pendingUpload struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Received int64  `json:"received"`
+}
+
+// EventsHandler streams changes to one directory as Server-Sent Events:
+//
+//	update   a child was added or changed; data is its FileItem
+//	remove   a child was deleted; data is {"path": ...}
+//	uploads  the uploads in progress in the directory
+//	reload   changes were dropped and the listing should be refetched
+//
+// The stream ends when shutdown is closed.
+func EventsHandler(idx *index.Index, m *ignore.Matcher, shutdown <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir := path.Clean("/" + r.URL.Query().Get("path"))
+		if m.Ignored(dir, true) {
+			http.NotFound(w, r)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		fmt.Fprint(w, "retry: 3000\n\n")
+		if err := rc.Flush(); err != nil {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		sub := idx.Subscribe(1024)
+		defer idx.Unsubscribe(sub)
+		tracker := activity.FromContext(r.Context())
+
+		var writeErr error
+		lastWrite := time.Now()
+		send := func(event string, v any) {
+			if writeErr != nil {
+				return
+			}
+			data, _ := json.Marshal(v)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			writeErr = rc.Flush()
+			lastWrite = time.Now()
+		}
+
+		ticker := time.NewTicker(eventsInterval)
+		defer ticker.Stop()
+		// Changes are batched per tick so a large copy becomes one update
+		// per affected child rather than one per file.
+		pending := map[string]struct{}{}
+		lastUploads := "[]"
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-shutdown:
+				return
+			case c := <-sub.C:
+				if child, ok := childOf(dir, c.Path); ok {
+					pending[child] = struct{}{}
+				}
+				continue
+			case <-ticker.C:
+			}
+
+			if sub.Resync() {
+				clear(pending)
+				send("reload", struct{}{})
+			}
+			for child := range pending {
+				if e, ok := idx.Stat(child); ok {
+					item := entryToItem(e)
+					item.Name = e.Name
+					send("update", item)
+				} else {
+					send("remove", map[string]string{"path": child})
+				}
+				delete(pending, child)
+			}
+
+			uploads := []pendingUpload{}
+			for _, u := range tracker.Snapshot().Uploads {
+				if path.Dir(u.Path) == dir && !m.Ignored(u.Path, false) {
+					uploads = append(uploads, pendingUpload{path.Base(u.Path), u.Path, u.Size, u.Received})
+				}
+			}
+			if data, _ := json.Marshal(uploads); string(data) != lastUploads {
+				lastUploads = string(data)
+				send("uploads", uploads)
+			}
+
+			if writeErr == nil && time.Since(lastWrite) > eventsKeepalive {
+				fmt.Fprint(w, ": keepalive\n\n")
+				writeErr = rc.Flush()
+				lastWrite = time.Now()
+			}
+			if writeErr != nil {
+				return
+			}
+		}
+	}
+}
+
+// childOf maps a changed path to the entry of dir it affects: the path itself
+// for a direct child, or the child directory containing it.
+func childOf(dir, p string) (string, bool) {
+	prefix := dir
+	if dir != "/" {
+		prefix += "/"
+	}
+	rest, ok := strings.CutPrefix(p, prefix)
+	if !ok || rest == "" {
+		return "", false
+	}
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest = rest[:i]
+	}
+	return prefix + rest, true
+}
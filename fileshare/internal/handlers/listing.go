@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"fileshare/internal/proxy"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const listCookieMaxAge = 365 * 24 * 60 * 60
+
+// ListOptions controls the order, filter and layout of a listing. Sort, Order
+// and View fall back to cookies so the choice sticks across folders.
+type ListOptions struct {
+	Sort   string
+	Order  string
+	Filter string
+	View   string
+
+	query url.Values
+}
+
+var listChoices = map[string][]string{
+	"sort":  {"name", "size", "mtime", "type"},
+	"order": {"asc", "desc"},
+	"view":  {"grid", "list"},
+}
+
+func parseListOptions(w http.ResponseWriter, r *http.Request) ListOptions {
+	q := r.URL.Query()
+	pick := func(key string) string {
+		allowed := listChoices[key]
+		if v := q.Get(key); slices.Contains(allowed, v) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     "fs_" + key,
+				Value:    v,
+				Path:     "/",
+				MaxAge:   listCookieMaxAge,
+				Secure:   proxy.Scheme(r) == "https",
+				SameSite: http.SameSiteLaxMode,
+			})
+			return v
+		}
+		if c, err := r.Cookie("fs_" + key); err == nil && slices.Contains(allowed, c.Value) {
+			return c.Value
+		}
+		return allowed[0]
+	}
+
+	return ListOptions{
+		Sort:   pick("sort"),
+		Order:  pick("order"),
+		View:   pick("view"),
+		Filter: strings.TrimSpace(q.Get("filter")),
+		query:  q,
+	}
+}
+
+// SortLink returns the href for a column header, flipping the order when the
+// column is already the active sort.
+func (o ListOptions) SortLink(field string) template.URL {
+	q := url.Values{}
+	for k, v := range o.query {
+		q[k] = v
+	}
+	order := "asc"
+	if o.Sort == field && o.Order == "asc" {
+		order = "desc"
+	}
+	q.Set("sort", field)
+	q.Set("order", order)
+	return template.URL("?" + q.Encode())
+}
+
+// Apply filters items by name and sorts them with directories first.
+func (o ListOptions) Apply(items []FileItem) []FileItem {
+	if o.Filter != "" {
+		needle := strings.ToLower(o.Filter)
+		kept := items[:0]
+		for _, item := range items {
+			if strings.Contains(strings.ToLower(item.Name), needle) {
+				kept = append(kept, item)
+			}
+		}
+		items = kept
+	}
+
+	less := func(a, b FileItem) bool {
+		switch o.Sort {
+		case "size":
+			if a.Bytes != b.Bytes {
+				return a.Bytes < b.Bytes
+			}
+		case "mtime":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		case "type":
+			ta, tb := strings.ToLower(filepath.Ext(a.Name)), strings.ToLower(filepath.Ext(b.Name))
+			if ta != tb {
+				return ta < tb
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].IsDir != items[j].IsDir {
+			return items[i].IsDir
+		}
+		if o.Order == "desc" {
+			return less(items[j], items[i])
+		}
+		return less(items[i], items[j])
+	})
+	return items
+}
+
+func formatModTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
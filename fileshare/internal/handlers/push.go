@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fileshare/internal/activity"
+	"fileshare/internal/logging"
+	"fileshare/internal/push"
+	"fileshare/internal/templates"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// offerPollWait is how long GET /push/offer?wait=1 holds the request
+	// waiting for an answer.
+	offerPollWait = 25 * time.Second
+	maxOfferFiles = 10000
+)
+
+// offerRequest is what "fileshare push" posts to /push/offer.
+type offerRequest struct {
+	From  string      `json:"from"`
+	Files []push.File `json:"files"`
+}
+
+// offerResponse tells the sender where the offer stands and, once it is
+// accepted, how to upload.
+type offerResponse struct {
+	push.Offer
+	Dir            string `json:"dir,omitempty"`
+	ChunkSize      int64  `json:"chunkSize,omitempty"`
+	ParallelChunks int    `json:"parallelChunks,omitempty"`
+}
+
+// PushOfferHandler lets senders offer files at POST /push/offer and wait
+// for the answer at GET /push/offer?id=ID&wait=1. Accepted files are sent
+// through /upload into the root directory.
+func PushOfferHandler(inbox *push.Inbox, opts UploadOptions) http.HandlerFunc {
+	respond := func(w http.ResponseWriter, o push.Offer) {
+		resp := offerResponse{Offer: o}
+		if o.State == push.StateAccepted {
+			resp.Dir = "/"
+			resp.ChunkSize = opts.ChunkSize
+			resp.ParallelChunks = opts.ParallelChunks
+		}
+		writeJSON(w, resp)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+		switch r.Method {
+		case http.MethodPost:
+			var req offerRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&req); err != nil {
+				http.Error(w, "Invalid offer", http.StatusBadRequest)
+				return
+			}
+			if err := validateOffer(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			o := inbox.Add(req.From, activity.ClientAddr(r), req.Files)
+			logger.Info("Push offered", "offer", o.ID, "from", o.From, "files", len(o.Files), "bytes", o.Total)
+			activity.FromContext(r.Context()).Event("%s wants to send %d file(s), %s", o.From, len(o.Files), formatSize(o.Total))
+			w.WriteHeader(http.StatusAccepted)
+			respond(w, o)
+
+		case http.MethodGet:
+			id := r.URL.Query().Get("id")
+			var o push.Offer
+			var err error
+			if r.URL.Query().Get("wait") == "1" {
+				ctx, cancel := context.WithTimeout(r.Context(), offerPollWait)
+				o, err = inbox.Wait(ctx, id)
+				cancel()
+			} else {
+				o, err = inbox.Get(id)
+			}
+			if err != nil {
+				http.Error(w, "Unknown offer", http.StatusNotFound)
+				return
+			}
+			respond(w, o)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func validateOffer(req *offerRequest) error {
+	req.From = strings.TrimSpace(req.From)
+	if req.From == "" || len(req.From) > 64 {
+		return errors.New("from must be 1 to 64 characters")
+	}
+	if len(req.Files) == 0 || len(req.Files) > maxOfferFiles {
+		return fmt.Errorf("offer 1 to %d files", maxOfferFiles)
+	}
+	for _, f := range req.Files {
+		clean := path.Clean("/" + f.Name)
+		if f.Name == "" || clean != "/"+f.Name || f.Size < 0 {
+			return fmt.Errorf("invalid file %q", f.Name)
+		}
+	}
+	return nil
+}
+
+type pushPage struct {
+	Offers  []pushOffer `json:"offers"`
+	Message string      `json:"message,omitempty"`
+}
+
+type pushOffer struct {
+	push.Offer
+	Size string `json:"sizeText"`
+	Age  string `json:"age"`
+}
+
+// PushInboxHandler shows the receiver the pending offers at /push and takes
+// their answer at POST /push/answer?id=ID&accept=1.
+func PushInboxHandler(inbox *push.Inbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/push/answer" {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !sameOrigin(r) {
+				http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+				return
+			}
+			id, accept := r.FormValue("id"), r.FormValue("accept") == "1"
+			msg := "Declined"
+			if accept {
+				msg = "Accepted, the files will appear in the root folder"
+			}
+			if err := inbox.Answer(id, accept); err != nil {
+				msg = "That offer was already answered or has expired"
+			} else {
+				logging.FromContext(r.Context()).Info("Push answered", "offer", id, "accepted", accept)
+			}
+			http.Redirect(w, r, "/push?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+			return
+		}
+
+		now := time.Now()
+		page := pushPage{Message: r.URL.Query().Get("msg")}
+		for _, o := range inbox.Pending() {
+			page.Offers = append(page.Offers, pushOffer{Offer: o, Size: formatSize(o.Total), Age: now.Sub(o.Created).Round(time.Second).String()})
+		}
+		if r.URL.Query().Get("format") == "json" {
+			writeJSON(w, page)
+			return
+		}
+		t, err := template.New("push").Parse(templates.PushTpl)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+		t.Execute(w, page)
+	}
+}
+
+// RequireLocal lets requests from this machine through to next. Others go
+// to remote, typically next wrapped in RequireAdmin, or are refused when
+// remote is nil.
+func RequireLocal(next, remote http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ip := net.ParseIP(activity.ClientAddr(r)); ip != nil && ip.IsLoopback() {
+			next(w, r)
+			return
+		}
+		if remote == nil {
+			http.Error(w, "Only available on this computer", http.StatusForbidden)
+			return
+		}
+		remote(w, r)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fileshare/internal/qrcode"
+	"fileshare/internal/templates"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// QRHandler serves the server's QR code at /qr: a full-page view for
+// showing on another screen, or the bare image with ?format=png or svg.
+func QRHandler(target string) http.HandlerFunc {
+	shown := target
+	if u, err := url.Parse(target); err == nil {
+		shown = u.Redacted()
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// The code may carry credentials; keep it out of shared caches.
+		w.Header().Set("Cache-Control", "no-store")
+
+		var data []byte
+		var err error
+		switch r.URL.Query().Get("format") {
+		case "png":
+			w.Header().Set("Content-Type", "image/png")
+			data, err = qrcode.PNG(target)
+		case "svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			data, err = qrcode.SVG(target)
+		case "":
+			t, err := template.New("qr").Parse(templates.QRTpl)
+			if err != nil {
+				http.Error(w, "Template error", http.StatusInternalServerError)
+				return
+			}
+			t.Execute(w, struct{ URL string }{shown})
+			return
+		default:
+			http.Error(w, "Unknown format, use png or svg", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Could not draw QR code", http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}
+}
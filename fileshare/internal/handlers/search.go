@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fileshare/internal/index"
+	"fileshare/internal/logging"
+	"fileshare/internal/templates"
+	"fmt"
+	"html"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultResultLimit = 200
+
+// ContentResult is a content search hit with snippets rendered for the page.
+type ContentResult struct {
+	Path     string
+	Snippets []template.HTML
+}
+
+// SearchHandler serves /search?q= as a results page, or JSON with format=json.
+// Content matches are included when content is not nil.
+func SearchHandler(idx *index.Index, content *index.ContentIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+		limit := parseLimit(r, defaultResultLimit)
+		results := idx.Search(query, limit)
+
+		var hits []index.ContentHit
+		if content != nil {
+			hits = content.Search(query, limit)
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			writeJSON(w, struct {
+				Query   string             `json:"query"`
+				Ready   bool               `json:"ready"`
+				Results []index.Entry      `json:"results"`
+				Content []index.ContentHit `json:"content,omitempty"`
+			}{query, idx.Ready(), results, hits})
+			return
+		}
+
+		var contentResults []ContentResult
+		for _, hit := range hits {
+			cr := ContentResult{Path: hit.Path}
+			for _, s := range hit.Snippets {
+				cr.Snippets = append(cr.Snippets, highlight(s))
+			}
+			contentResults = append(contentResults, cr)
+		}
+
+		items := make([]FileItem, 0, len(results))
+		for _, e := range results {
+			items = append(items, entryToItem(e))
+		}
+
+		opts := parseListOptions(w, r)
+		data := browsePage{
+			BreadCrumbs: []BreadCrumb{
+				{Name: "Home", Link: "/"},
+				{Name: fmt.Sprintf("Search: %s (%d)", query, len(items)), Link: r.URL.RequestURI()},
+			},
+			Files:       opts.Apply(items),
+			CurrentPath: "/",
+			Query:       query,
+			Hits:        contentResults,
+			Options:     opts,
+		}
+
+		t, err := template.New("webpage").Parse(templates.BrowseTpl)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Template parse error", "err", err)
+			http.Error(w, "Template error", 500)
+			return
+		}
+		if err := t.Execute(w, data); err != nil {
+			logging.FromContext(r.Context()).Error("Template execution error", "err", err)
+		}
+	}
+}
+
+// RecentHandler serves /recent as JSON, newest files first.
+func RecentHandler(idx *index.Index) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, idx.Recent(parseLimit(r, 50)))
+	}
+}
+
+// highlight escapes a snippet and wraps its matches in <mark>.
+func highlight(s index.Snippet) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<span class=\"line\">%d</span> ", s.Line)
+	last := 0
+	for _, m := range s.Matches {
+		b.WriteString(html.EscapeString(s.Text[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(s.Text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(s.Text[last:]))
+	return template.HTML(b.String())
+}
+
+func entryToItem(e index.Entry) FileItem {
+	downloadURL := e.Path
+	size := formatSize(e.Size)
+	if e.IsDir {
+		downloadURL = fmt.Sprintf("/zip?path=%s", e.Path)
+	}
+	return FileItem{
+		Name:        e.Path,
+		Path:        e.Path,
+		IsDir:       e.IsDir,
+		Size:        size,
+		Bytes:       e.Size,
+		Files:       e.Files,
+		ModTime:     e.ModTime,
+		Modified:    formatModTime(e.ModTime),
+		DownloadURL: downloadURL,
+	}
+}
+
+func parseLimit(r *http.Request, def int) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return def
+	}
+	if limit > 5000 {
+		return 5000
+	}
+	return limit
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("JSON encode error", "err", err)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fileshare/internal/logging"
+	"fileshare/internal/proxy"
+	"fileshare/internal/share"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ShareLinkHandler opens a share link at /s/<token>: it remembers the link in
+// a cookie and sends the visitor to the shared path.
+func ShareLinkHandler(links *share.Links) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, ok := links.Visit(strings.TrimPrefix(r.URL.Path, "/s/"))
+		if !ok {
+			http.Error(w, "This link has expired or was revoked", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Info("Share link opened", "path", link.Path)
+		http.SetCookie(w, &http.Cookie{
+			Name:     share.CookieName,
+			Value:    link.Token,
+			Path:     "/",
+			Expires:  link.Expires,
+			Secure:   proxy.Scheme(r) == "https",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, (&url.URL{Path: link.Path}).String(), http.StatusSeeOther)
+	}
+}
+
+// RequireLink serves next only to visitors whose share link covers the path
+// that scope picks out of the request. A nil links lets everyone through.
+func RequireLink(links *share.Links, scope func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	if links == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(share.CookieName); err == nil {
+			if link, ok := links.Lookup(c.Value); ok && link.Allows(scope(r)) {
+				next(w, r)
+				return
+			}
+		}
+		http.Error(w, "Open this server through a share link to see this", http.StatusForbidden)
+	}
+}
+
+// ScopePath scopes RequireLink to the file or folder in the URL path.
+func ScopePath(r *http.Request) string { return r.URL.Path }
+
+// ScopeQuery scopes RequireLink to the folder from the query parameter
+// key, as /zip?path= and /upload?dir= do.
+func ScopeQuery(key string) func(r *http.Request) string {
+	return func(r *http.Request) string { return r.URL.Query().Get(key) }
+}
+
+// ScopeRoot is for views of the whole share, such as search, which only a
+// link to the root may see.
+func ScopeRoot(r *http.Request) string { return "/" }
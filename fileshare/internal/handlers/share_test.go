@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fileshare/internal/share"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShareLink(t *testing.T) {
+	links := share.New()
+	link := links.Create("/docs", 0)
+	files := RequireLink(links, ScopePath, func(w http.ResponseWriter, r *http.Request) {})
+	zip := RequireLink(links, ScopeQuery("path"), func(w http.ResponseWriter, r *http.Request) {})
+	search := RequireLink(links, ScopeRoot, func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	ShareLinkHandler(links)(rec, httptest.NewRequest(http.MethodGet, "/s/"+link.Token, nil))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/docs" {
+		t.Fatalf("open link: status = %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != share.CookieName || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+
+	tests := []struct {
+		handler http.HandlerFunc
+		target  string
+		cookie  bool
+		status  int
+	}{
+		{files, "/docs/a.txt", true, http.StatusOK},
+		{files, "/docs/a.txt", false, http.StatusForbidden},
+		{files, "/top.txt", true, http.StatusForbidden},
+		{zip, "/zip?path=/docs/sub", true, http.StatusOK},
+		{zip, "/zip?path=/", true, http.StatusForbidden},
+		{search, "/search?q=a", true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.cookie {
+			req.AddCookie(cookies[0])
+		}
+		rec := httptest.NewRecorder()
+		tt.handler(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s (cookie %v): status = %d, want %d", tt.target, tt.cookie, rec.Code, tt.status)
+		}
+	}
+
+	// Revoking ends access for browsers that already hold the cookie.
+	links.Revoke(link.Token)
+	req := httptest.NewRequest(http.MethodGet, "/docs/a.txt", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	files(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("after revoke: status = %d, want 403", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	ShareLinkHandler(links)(rec, httptest.NewRequest(http.MethodGet, "/s/"+link.Token, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("revoked link: status = %d, want 404", rec.Code)
+	}
+}
@@ -2,19 +2,53 @@
 package handlers
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"fileshare/internal/activity"
+	"fileshare/internal/audit"
+	"fileshare/internal/cleanup"
+	"fileshare/internal/ignore"
+	"fileshare/internal/logging"
+	"fileshare/internal/metrics"
+	"fileshare/internal/push"
+	"fileshare/internal/ratelimit"
+	"fileshare/internal/storage"
 	"fileshare/internal/templates"
+	"fileshare/internal/worker"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-func ChunkedUploadHandler() http.HandlerFunc {
+const (
+	// chunkQueueWait is how long a chunk may wait for a buffer or a write
+	// slot before the client is told to retry after chunkRetryAfter seconds.
+	chunkQueueWait  = 10 * time.Second
+	chunkRetryAfter = "2"
+
+	// chunkBufferMemory caps the memory held by chunks that have been read
+	// from the network and are waiting to be written.
+	chunkBufferMemory = 256 << 20
+	defaultChunkSize  = 4 << 20
+)
+
+// UploadOptions tell the browser how to split files into chunks.
+type UploadOptions struct {
+	ChunkSize      int64
+	ParallelChunks int
+}
+
+func ChunkedUploadHandler(store storage.FS, wp *worker.Pool, m *ignore.Matcher, inbox *push.Inbox, auditLog *audit.Log, limiter *ratelimit.Limiter, opts UploadOptions) http.HandlerFunc {
+	buffers := newChunkBuffers(cmp.Or(opts.ChunkSize, defaultChunkSize), chunkBufferMemory)
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -23,7 +57,10 @@ func ChunkedUploadHandler() http.HandlerFunc {
 			if targetDir == "" {
 				targetDir = "/"
 			}
-			data := struct{ ReturnLink string }{ReturnLink: targetDir}
+			data := struct {
+				ReturnLink string
+				UploadOptions
+			}{ReturnLink: targetDir, UploadOptions: opts}
 			t, err := template.New("upload").Parse(templates.UploadTpl)
 			if err != nil {
 				http.Error(w, "Template error", http.StatusInternalServerError)
@@ -63,66 +100,156 @@ func ChunkedUploadHandler() http.HandlerFunc {
 
 			isFinal := r.Header.Get("X-Final-Chunk") == "true"
 
-			baseDir, _ := os.Getwd()
-			absUploadDir := filepath.Join(baseDir, relDir)
+			// X-Upload-ID is shared by every chunk of one file so their logs group together
+			uploadID := r.Header.Get("X-Upload-ID")
+			if uploadID == "" {
+				uploadID = filepath.Join(relDir, cleanName)
+			}
+			logger := logging.FromContext(r.Context()).With("upload_id", uploadID, "file", cleanName)
+			name := storage.Name(filepath.Join(relDir, cleanName))
+			urlPath := "/" + name
+			fileSize, _ := strconv.ParseInt(r.Header.Get("X-File-Size"), 10, 64)
+
+			// Temp file uses base name only for the .partial
+			tmpName := cleanup.PartialName(name)
+
+			// A sender pushing files may only send what the receiver
+			// accepted.
+			offerID := r.Header.Get(push.OfferHeader)
+			if offerID != "" {
+				if err := inbox.Check(offerID, activity.ClientAddr(r), name, fileSize); err != nil {
+					logger.Warn("Push chunk refused", "offer", offerID, "err", err)
+					http.Error(w, "Not part of an accepted offer", http.StatusForbidden)
+					return
+				}
+			}
+
+			// Nothing hidden from the listing may be written, least of all
+			// the server's own files kept inside the share.
+			if m.Ignored(urlPath, false) || m.Private(tmpName) {
+				logger.Warn("Upload to hidden path refused")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			tracker := activity.FromContext(r.Context())
+			if !isFinal && tracker.UploadsPaused() {
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, "Uploads are paused", http.StatusServiceUnavailable)
+				return
+			}
 
 			// For folder uploads, create subdirectories
-			fullFilePath := filepath.Join(absUploadDir, cleanName)
-			fileDir := filepath.Dir(fullFilePath)
+			fileDir := path.Dir(name)
 
 			// Create subdirectories if needed
-			if err := os.MkdirAll(fileDir, 0755); err != nil {
-				log.Printf("Failed to create directory: %v", err)
+			if err := store.MkdirAll(fileDir); err != nil {
+				logger.Error("Failed to create directory", "err", err)
 				http.Error(w, "Failed to create directory", http.StatusInternalServerError)
 				return
 			}
 
-			// Temp file uses base name only for the .partial
-			baseName := filepath.Base(cleanName)
-			tmpPath := filepath.Join(fileDir, "."+baseName+".partial")
-			finalPath := fullFilePath
-
 			if isFinal {
-				if err := os.Rename(tmpPath, finalPath); err != nil {
-					log.Printf("Failed to finalize: %v", err)
+				if err := store.Rename(tmpName, name); err != nil {
+					logger.Error("Failed to finalize", "err", err)
 					http.Error(w, "Failed to finalize", http.StatusInternalServerError)
 					return
 				}
-				log.Printf("Upload complete: %s", cleanName)
+				logger.Info("Upload complete")
+				tracker.UploadDone(uploadID, urlPath)
+				if auditLog != nil {
+					recordUpload(auditLog, r, store, name)
+				}
 				w.WriteHeader(http.StatusOK)
 				fmt.Fprintf(w, "0")
 				return
 			}
 
-			// Write chunk data
-			file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Printf("Failed to open temp file: %v", err)
-				http.Error(w, "Failed to create file", http.StatusInternalServerError)
+			// The chunk is read from the network first, into one of a
+			// bounded set of buffers, so a slow or rate-limited client never
+			// holds a disk writer while its bytes trickle in.
+			buf, ok := buffers.get(r.Context(), chunkQueueWait)
+			if !ok {
+				if r.Context().Err() == nil {
+					tooBusy(w, logger, errNoBuffer)
+				}
 				return
 			}
-			defer file.Close()
-
-			// Seek to correct offset fro parallel write operation
-			_, err = file.Seek(offset, 0)
-			if err != nil {
-				log.Printf("Failed to seek: %v", err)
-				http.Error(w, "Failed to seek in file", http.StatusInternalServerError)
+			defer buffers.put(buf)
+			n, err := readChunk(limiter.Reader(r, r.Body), buf)
+			if errors.Is(err, errChunkTooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				logger.Info("Client disconnected during chunk", "offset", offset, "err", err)
+				return
+			}
+			written := int64(n)
+			if offerID != "" && offset+written > fileSize {
+				http.Error(w, "Chunk runs past the offered file size", http.StatusRequestEntityTooLarge)
 				return
 			}
 
-			// Stream chunk body to file without memory buffering
-			written, err := io.Copy(file, r.Body)
+			// Only the disk work runs on the upload pool, so disk concurrency
+			// stays bounded; when it is saturated the client backs off and
+			// retries.
+			write := worker.JobFunc(func(ctx context.Context) error {
+				writeStart := time.Now()
+				file, err := store.OpenWrite(tmpName)
+				if err != nil {
+					return fmt.Errorf("open temp file: %w", err)
+				}
+				defer file.Close()
+
+				// Seek to correct offset fro parallel write operation
+				if _, err := file.Seek(offset, 0); err != nil {
+					return fmt.Errorf("seek: %w", err)
+				}
+
+				if _, err := file.Write(buf[:n]); err != nil {
+					return fmt.Errorf("write chunk: %w", err)
+				}
+
+				// Sync to ensure data hits disk before responding OK
+				if err := file.Sync(); err != nil {
+					return fmt.Errorf("sync: %w", err)
+				}
+				metrics.ChunkWriteDuration.Observe(time.Since(writeStart).Seconds())
+				return nil
+			})
+			ticket, err := wp.Enqueue(r.Context(), write, worker.Options{Client: clientKey(r)})
 			if err != nil {
-				log.Printf("Failed to write chunk: %v", err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				tooBusy(w, logger, err)
+				return
+			}
+			select {
+			case <-ticket.Started():
+			case <-r.Context().Done():
+				// Nobody is waiting for the answer, so this is not a
+				// rejection to count or a 429 to send.
+				if ticket.Cancel() {
+					logger.Info("Client disconnected while chunk was queued", "offset", offset)
+					return
+				}
+			case <-time.After(chunkQueueWait):
+				if ticket.Cancel() {
+					tooBusy(w, logger, worker.ErrQueueFull)
+					return
+				}
+			}
+			if err := ticket.Err(); r.Context().Err() != nil {
+				logger.Info("Client disconnected during chunk", "offset", offset)
+				return
+			} else if err != nil {
+				logger.Error("Failed to write chunk", "offset", offset, "err", err)
+				http.Error(w, "Failed to write chunk", http.StatusInternalServerError)
 				return
 			}
 
-			// Sync to ensure data hits disk before responding OK
-			file.Sync()
+			metrics.BytesUploaded.Add(float64(written))
+			tracker.UploadChunk(r, uploadID, urlPath, fileSize, written)
 
-			log.Printf("Chunk written: %s offset=%d size=%d", cleanName, offset, written)
+			logger.Debug("Chunk written", "offset", offset, "size", written)
 
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintf(w, "%d", written)
@@ -132,3 +259,79 @@ func ChunkedUploadHandler() http.HandlerFunc {
 		}
 	}
 }
+
+var (
+	errNoBuffer      = errors.New("no chunk buffer free")
+	errChunkTooLarge = errors.New("chunk larger than the configured chunk size")
+)
+
+// chunkBuffers hands out chunk-sized buffers, no more than fit in the
+// memory budget at once.
+type chunkBuffers struct {
+	size  int64
+	slots chan struct{}
+	pool  sync.Pool
+}
+
+func newChunkBuffers(size, budget int64) *chunkBuffers {
+	b := &chunkBuffers{size: size, slots: make(chan struct{}, max(1, budget/size))}
+	b.pool.New = func() any { return make([]byte, size) }
+	return b
+}
+
+// get waits up to wait for a free buffer.
+func (b *chunkBuffers) get(ctx context.Context, wait time.Duration) ([]byte, bool) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return b.pool.Get().([]byte), true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+func (b *chunkBuffers) put(buf []byte) {
+	b.pool.Put(buf)
+	<-b.slots
+}
+
+// readChunk reads a whole chunk body into buf, failing if it does not fit.
+func readChunk(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return n, nil
+	case err != nil:
+		return n, err
+	}
+	var extra [1]byte
+	if m, _ := io.ReadFull(r, extra[:]); m > 0 {
+		return n, errChunkTooLarge
+	}
+	return n, nil
+}
+
+// tooBusy asks the client to retry the chunk later; script.js honours
+// Retry-After on 429.
+func tooBusy(w http.ResponseWriter, logger *slog.Logger, reason error) {
+	metrics.Rejected.WithLabelValues("upload").Inc()
+	logger.Debug("Chunk rejected, write queue busy", "reason", reason)
+	w.Header().Set("Retry-After", chunkRetryAfter)
+	http.Error(w, "Server busy, retry shortly", http.StatusTooManyRequests)
+}
+
+// recordUpload hashes the finalized file and appends it to the audit log.
+func recordUpload(auditLog *audit.Log, r *http.Request, store storage.FS, name string) {
+	var size int64
+	if info, err := store.Stat(name); err == nil {
+		size = info.Size()
+	}
+	sum, err := audit.HashFile(store, name)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Audit hash failed", "err", err)
+	}
+	auditLog.Record(r, audit.ActionUpload, "/"+name, size, sum)
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fileshare/internal/cleanup"
+	"fileshare/internal/ignore"
+	"fileshare/internal/metrics"
+	"fileshare/internal/push"
+	"fileshare/internal/storage"
+	"fileshare/internal/worker"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// postChunk sends one chunk of name to dir. An empty body with final set
+// finalizes the upload.
+func postChunk(handler http.HandlerFunc, dir, name string, offset int64, body []byte, final bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/upload?dir="+dir, bytes.NewReader(body))
+	req.Header.Set("X-File-Name", name)
+	req.Header.Set("X-Chunk-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("X-Upload-ID", "test-"+name)
+	if final {
+		req.Header.Set("X-Final-Chunk", "true")
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestChunkedUploadOutOfOrder(t *testing.T) {
+	store := newTestStore()
+	handler := ChunkedUploadHandler(store, newTestPool(t, 2), newTestMatcher(t), nil, nil, nil, UploadOptions{ChunkSize: 4})
+	data := []byte("0123456789")
+
+	// Chunks arrive in parallel and the last one first, as they can from
+	// the browser uploader.
+	var wg sync.WaitGroup
+	for _, offset := range []int64{8, 4, 0} {
+		wg.Go(func() {
+			chunk := data[offset:min(offset+4, int64(len(data)))]
+			if rec := postChunk(handler, "/docs", "new.bin", offset, chunk, false); rec.Code != http.StatusOK {
+				t.Errorf("chunk at %d: status = %d, body %q", offset, rec.Code, rec.Body)
+			}
+		})
+	}
+	wg.Wait()
+	if _, err := store.Stat("docs/new.bin"); err == nil {
+		t.Fatal("file visible before it was finalized")
+	}
+
+	if rec := postChunk(handler, "/docs", "new.bin", int64(len(data)), nil, true); rec.Code != http.StatusOK {
+		t.Fatalf("finalize: status = %d, body %q", rec.Code, rec.Body)
+	}
+	got, err := fs.ReadFile(store, "docs/new.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("uploaded %q, want %q", got, data)
+	}
+	if _, err := store.Stat("docs/.new.bin.partial"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("partial left behind: %v", err)
+	}
+}
+
+func TestChunkedUploadRejects(t *testing.T) {
+	store := newTestStore()
+	root := t.TempDir()
+	m, err := ignore.New(root, []string{"*.log"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Hide(root, filepath.Join(root, "idx.gob"))
+	handler := ChunkedUploadHandler(store, newTestPool(t, 1), m, nil, nil, nil, UploadOptions{ChunkSize: 4})
+
+	tests := []struct {
+		dir, name string
+		body      string
+		final     bool
+		status    int
+	}{
+		{dir: "/docs", name: "big.bin", body: "12345", status: http.StatusRequestEntityTooLarge},
+		{dir: "/docs", name: "../escape.bin", body: "1", status: http.StatusForbidden},
+		{dir: "/docs", name: "debug.log", body: "1", status: http.StatusForbidden},
+		{dir: "/docs", name: "debug.log", final: true, status: http.StatusForbidden},
+		{dir: "/docs", name: ".secret", body: "1", status: http.StatusForbidden},
+		{dir: "/", name: ".git/config", body: "1", status: http.StatusForbidden},
+		{dir: "/", name: "idx.gob", body: "1", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if rec := postChunk(handler, tt.dir, tt.name, 0, []byte(tt.body), tt.final); rec.Code != tt.status {
+			t.Errorf("%s in %s: status = %d, want %d", tt.name, tt.dir, rec.Code, tt.status)
+		}
+	}
+	if got, _ := fs.ReadFile(store, "docs/debug.log"); string(got) != "ignored" {
+		t.Errorf("docs/debug.log = %q, want it untouched", got)
+	}
+	if partials := cleanup.ListPartials(store); len(partials) != 0 {
+		t.Errorf("rejected chunks left partials: %+v", partials)
+	}
+}
+
+func TestChunkedUploadClientGoneWhileQueued(t *testing.T) {
+	pool := newTestPool(t, 1)
+	handler := ChunkedUploadHandler(newTestStore(), pool, newTestMatcher(t), nil, nil, nil, UploadOptions{ChunkSize: 4})
+
+	// Keep the only worker busy so the chunk has to queue.
+	release := make(chan struct{})
+	busy, err := pool.Enqueue(context.Background(), worker.JobFunc(func(ctx context.Context) error {
+		<-release
+		return nil
+	}), worker.Options{Client: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-busy.Started()
+	defer close(release)
+
+	rejected := testutil.ToFloat64(metrics.Rejected.WithLabelValues("upload"))
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/upload?dir=/docs", strings.NewReader("1234"))
+	req.Header.Set("X-File-Name", "gone.bin")
+	req.Header.Set("X-Chunk-Offset", "0")
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler(rec, req)
+		close(done)
+	}()
+	for pool.QueueLength() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if rec.Code == http.StatusTooManyRequests {
+		t.Error("disconnected client was told to retry")
+	}
+	if got := testutil.ToFloat64(metrics.Rejected.WithLabelValues("upload")); got != rejected {
+		t.Errorf("rejected count went from %v to %v", rejected, got)
+	}
+	if pool.QueueLength() != 0 {
+		t.Error("chunk left in the queue")
+	}
+}
+
+func TestChunkedUploadPushOffer(t *testing.T) {
+	inbox := push.NewInbox(time.Minute)
+	files := []push.File{{Name: "a.bin", Size: 4}, {Name: "dir/b.bin", Size: 2}}
+	accepted := inbox.Add("laptop", "192.0.2.1", files)
+	inbox.Answer(accepted.ID, true)
+	declined := inbox.Add("laptop", "192.0.2.1", files)
+	inbox.Answer(declined.ID, false)
+	pending := inbox.Add("laptop", "192.0.2.1", files)
+	handler := ChunkedUploadHandler(newTestStore(), newTestPool(t, 1), newTestMatcher(t), inbox, nil, nil, UploadOptions{ChunkSize: 4})
+
+	tests := []struct {
+		name   string
+		offer  string
+		client string
+		dir    string
+		file   string
+		size   string
+		body   string
+		status int
+	}{
+		{name: "accepted", offer: accepted.ID, file: "a.bin", size: "4", body: "1234", status: http.StatusOK},
+		{name: "accepted in folder", offer: accepted.ID, file: "dir/b.bin", size: "2", body: "12", status: http.StatusOK},
+		{name: "pending", offer: pending.ID, file: "a.bin", size: "4", body: "1234", status: http.StatusForbidden},
+		{name: "declined", offer: declined.ID, file: "a.bin", size: "4", body: "1234", status: http.StatusForbidden},
+		{name: "unknown offer", offer: "nope", file: "a.bin", size: "4", body: "1234", status: http.StatusForbidden},
+		{name: "other sender", offer: accepted.ID, client: "192.0.2.2:1234", file: "a.bin", size: "4", body: "1234", status: http.StatusForbidden},
+		{name: "file not offered", offer: accepted.ID, file: "c.bin", size: "4", body: "1234", status: http.StatusForbidden},
+		{name: "other folder", offer: accepted.ID, dir: "/docs", file: "a.bin", size: "4", body: "1234", status: http.StatusForbidden},
+		{name: "other size", offer: accepted.ID, file: "a.bin", size: "8", body: "1234", status: http.StatusForbidden},
+		{name: "past the size", offer: accepted.ID, file: "dir/b.bin", size: "2", body: "123", status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/upload?dir="+cmp.Or(tt.dir, "/"), strings.NewReader(tt.body))
+			req.RemoteAddr = cmp.Or(tt.client, "192.0.2.1:1234")
+			req.Header.Set(push.OfferHeader, tt.offer)
+			req.Header.Set("X-File-Name", tt.file)
+			req.Header.Set("X-File-Size", tt.size)
+			req.Header.Set("X-Chunk-Offset", "0")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+const benchChunkSize = 1 << 20
+
+// BenchmarkChunkedUpload compares chunk upload throughput with the disk
+// writes bounded by the upload pool (NumCPU workers, as main sets it up)
+// against one writer per client, which is how uploads behaved before the
+// pool was used. In the slow cases every other client sends its chunks at
+// about 16 MB/s, like a phone on Wi-Fi.
+//
+//	go test ./internal/handlers -run '^$' -bench ChunkedUpload
+func BenchmarkChunkedUpload(b *testing.B) {
+	for _, slow := range []bool{false, true} {
+		for _, clients := range []int{4, 16, 64} {
+			for _, mode := range []string{"pool", "unbounded"} {
+				workers := runtime.NumCPU()
+				if mode == "unbounded" {
+					workers = clients
+				}
+				name := fmt.Sprintf("%s/clients=%d", mode, clients)
+				if slow {
+					name = "slow/" + name
+				}
+				b.Run(name, func(b *testing.B) {
+					benchmarkUpload(b, workers, clients, slow)
+				})
+			}
+		}
+	}
+}
+
+func benchmarkUpload(b *testing.B, workers, clients int, slow bool) {
+	pool := worker.NewPool(workers, 500)
+	pool.Start()
+	defer pool.Stop()
+	handler := ChunkedUploadHandler(storage.NewLocal(b.TempDir()), pool, nil, nil, nil, nil, UploadOptions{ChunkSize: benchChunkSize})
+	chunk := bytes.Repeat([]byte{0xab}, benchChunkSize)
+
+	var nextClient, retries atomic.Int64
+	b.SetBytes(benchChunkSize)
+	b.SetParallelism(max(1, (clients+runtime.GOMAXPROCS(0)-1)/runtime.GOMAXPROCS(0)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := nextClient.Add(1)
+		addr := fmt.Sprintf("10.0.%d.%d:40000", id/256, id%256)
+		name := fmt.Sprintf("client-%d.bin", id)
+		var offset int64
+		for pb.Next() {
+			for {
+				var body io.Reader = bytes.NewReader(chunk)
+				if slow && id%2 == 0 {
+					body = &slowReader{r: body}
+				}
+				req := httptest.NewRequest(http.MethodPost, "/upload?dir=/", body)
+				req.RemoteAddr = addr
+				req.Header.Set("X-File-Name", name)
+				req.Header.Set("X-Chunk-Offset", strconv.FormatInt(offset, 10))
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+				if rec.Code == http.StatusOK {
+					break
+				}
+				if rec.Code != http.StatusTooManyRequests {
+					b.Errorf("chunk at %d: %d %s", offset, rec.Code, rec.Body)
+					return
+				}
+				retries.Add(1)
+			}
+			offset += benchChunkSize
+		}
+	})
+	b.ReportMetric(float64(retries.Load())/float64(b.N), "retries/op")
+}
+
+// slowReader hands out at most 32 KiB every 2ms.
+type slowReader struct {
+	r io.Reader
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(2 * time.Millisecond)
+	return s.r.Read(p[:min(len(p), 32<<10)])
+}
@@ -3,17 +3,44 @@ package handlers
 import (
 	"archive/zip"
 	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fileshare/internal/activity"
+	"fileshare/internal/audit"
+	"fileshare/internal/ignore"
+	"fileshare/internal/index"
+	"fileshare/internal/logging"
+	"fileshare/internal/metrics"
+	"fileshare/internal/ratelimit"
+	"fileshare/internal/storage"
+	"fileshare/internal/templates"
 	"fileshare/internal/worker"
 	"fmt"
+	"html/template"
 	"io"
-	"log"
+	"io/fs"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-const transferBufferSize = 1 << 20
+const (
+	transferBufferSize = 1 << 20
+	smallZipSize       = 64 << 20
+
+	queueReportDelay  = 2 * time.Second
+	queueRetrySeconds = 3
+	// heldTicketTTL is how long a queued browser download keeps its place
+	// without the page reloading.
+	heldTicketTTL = 4 * queueRetrySeconds * time.Second
+)
 
 var compressedExts = map[string]bool{
 	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
@@ -23,41 +50,86 @@ var compressedExts = map[string]bool{
 }
 
 type ZipJob struct {
+	FS storage.FS
+	// SourcePath is the folder to archive, as an FS name.
 	SourcePath string
+	Ignore     *ignore.Matcher
 	Writer     http.ResponseWriter
-	Done       chan struct{}
+	Written    *int64
 }
 
-func (z ZipJob) Process() {
-	defer close(z.Done)
+// rootName is what a zip of the whole share is called: the name of the
+// shared directory on disk, or "fileshare" for a store without one.
+func rootName(store storage.FS) string {
+	if r, ok := store.(interface{ Root() string }); ok {
+		if name := filepath.Base(r.Root()); name != string(filepath.Separator) && name != "." {
+			return name
+		}
+	}
+	return "fileshare"
+}
 
-	fileName := filepath.Base(z.SourcePath) + ".zip"
+// Process streams the folder as a zip. It stops reading disk as soon as ctx
+// is done; the archive is then left without its central directory so the
+// client cannot mistake it for a complete one.
+func (z ZipJob) Process(ctx context.Context) error {
+	metrics.ActiveZipJobs.Inc()
+	defer metrics.ActiveZipJobs.Dec()
+
+	folder := path.Base(z.SourcePath)
+	if folder == "." {
+		folder = rootName(z.FS)
+	}
+	fileName := folder + ".zip"
 	z.Writer.Header().Set("Content-Type", "application/octet-stream")
 	z.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; fileName=\"%s\"", fileName))
 
-	bw := bufio.NewWriterSize(z.Writer, transferBufferSize)
-	defer bw.Flush()
+	cw := &metrics.CountingWriter{ResponseWriter: z.Writer}
+	defer func() {
+		metrics.BytesDownloaded.Add(float64(cw.Written))
+		if z.Written != nil {
+			*z.Written = cw.Written
+		}
+	}()
 
+	bw := bufio.NewWriterSize(cw, transferBufferSize)
 	zipWriter := zip.NewWriter(bw)
-	defer zipWriter.Close()
 
-	err := filepath.Walk(z.SourcePath, func(filePath string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+	err := fs.WalkDir(z.FS, z.SourcePath, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return nil
+		}
+
+		if z.Ignore.Ignored(name, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
 			return nil
 		}
 
-		relPath, err := filepath.Rel(filepath.Dir(z.SourcePath), filePath)
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
 		if err != nil {
 			return err
 		}
-
 		header, err := zip.FileInfoHeader(info)
 		if err != nil {
 			return err
 		}
-		header.Name = relPath
+		// Entries sit under the folder's own name.
+		rel := name
+		if z.SourcePath != "." {
+			rel = strings.TrimPrefix(name, z.SourcePath+"/")
+		}
+		header.Name = folder + "/" + rel
 
-		ext := strings.ToLower(filepath.Ext(filePath))
+		ext := strings.ToLower(path.Ext(name))
 		if compressedExts[ext] {
 			header.Method = zip.Store
 		} else {
@@ -69,48 +141,249 @@ func (z ZipJob) Process() {
 			return err
 		}
 
-		fsFile, err := os.Open(filePath)
+		fsFile, err := z.FS.Open(name)
 		if err != nil {
 			return err
 		}
 		defer fsFile.Close()
 
 		buf := make([]byte, transferBufferSize)
-		_, err = io.CopyBuffer(zipFileEntry, fsFile, buf)
+		_, err = io.CopyBuffer(zipFileEntry, contextReader{ctx, fsFile}, buf)
 		return err
 	})
+	if err == nil {
+		err = zipWriter.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	return err
+}
+
+// contextReader stops a copy once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// heldTicket keeps a browser's place in the zip queue between reloads of
+// the queued page. Once a worker picks the job up it waits for a request
+// presenting the token to claim it and streams the zip to that request.
+type heldTicket struct {
+	token  string
+	path   string
+	client string
+	ticket *worker.Ticket
+	claim  chan zipClaim
+	// expire withdraws the job, or stops a started one that nobody claimed,
+	// unless the browser comes back within heldTicketTTL.
+	expire *time.Timer
+	cancel context.CancelFunc
+}
+
+// zipClaim hands a request's writer to a started job.
+type zipClaim struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	written *int64
+	done    chan error
+}
+
+// heldTickets are the zip jobs queued by one handler, by token.
+type heldTickets struct {
+	mu      sync.Mutex
+	tickets map[string]*heldTicket
+}
+
+// get returns the ticket for token if it was issued to client for path.
+func (h *heldTickets) get(token, path, client string) *heldTicket {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.tickets[token]
+	if t == nil || t.path != path || t.client != client {
+		return nil
+	}
+	return t
+}
+
+// drop forgets t and withdraws or stops its job.
+func (h *heldTickets) drop(t *heldTicket) {
+	h.mu.Lock()
+	delete(h.tickets, t.token)
+	h.mu.Unlock()
+	t.expire.Stop()
+	t.cancel()
+}
+
+func (h *heldTickets) enqueue(wp *worker.Pool, job ZipJob, path, client string, opts worker.Options) (*heldTicket, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &heldTicket{token: newToken(), path: path, client: client, claim: make(chan zipClaim), cancel: cancel}
+	run := worker.JobFunc(func(ctx context.Context) error {
+		select {
+		case c := <-t.claim:
+			// The job now lives as long as the claiming request.
+			ctx, stop := context.WithCancel(ctx)
+			defer stop()
+			defer context.AfterFunc(c.ctx, stop)()
+			job.Writer, job.Written = c.w, c.written
+			err := job.Process(ctx)
+			c.done <- err
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	ticket, err := wp.Enqueue(ctx, run, opts)
 	if err != nil {
-		log.Printf("Zip error: %v", err)
+		cancel()
+		return nil, err
 	}
+	t.ticket = ticket
+	h.mu.Lock()
+	t.expire = time.AfterFunc(heldTicketTTL, func() { h.drop(t) })
+	h.tickets[t.token] = t
+	h.mu.Unlock()
+	return t, nil
 }
 
-func ZipHandlerFactory(wp *worker.Pool) http.HandlerFunc {
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func ZipHandlerFactory(store storage.FS, wp *worker.Pool, idx *index.Index, m *ignore.Matcher, auditLog *audit.Log, limiter *ratelimit.Limiter) http.HandlerFunc {
+	held := &heldTickets{tickets: map[string]*heldTicket{}}
 	return func(w http.ResponseWriter, r *http.Request) {
 		relativePath := r.URL.Query().Get("path")
 		if strings.Contains(relativePath, "..") {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
-		baseDir, _ := os.Getwd()
-		fullSourcePath := filepath.Join(baseDir, relativePath)
-
-		doneChan := make(chan struct{})
-		job := ZipJob{
-			SourcePath: fullSourcePath,
-			Writer:     w,
-			Done:       doneChan,
+		if m.Ignored(relativePath, true) {
+			http.NotFound(w, r)
+			return
 		}
+
+		logger := logging.FromContext(r.Context())
+		client := activity.ClientAddr(r)
 		ctx := r.Context()
-		if !wp.TrySubmit(ctx, job) {
-			http.Error(w, "Server busy, please try again", http.StatusServiceUnavailable)
-			return
+
+		// A reload of the queued page keeps its place in line.
+		t := held.get(r.URL.Query().Get("ticket"), relativePath, client)
+		if t == nil {
+			// Small folders jump ahead of large ones so quick downloads are not
+			// stuck behind a multi-gigabyte archive.
+			priority := worker.PriorityNormal
+			if e, ok := idx.Stat(relativePath); ok && idx.Ready() && e.Size < smallZipSize {
+				priority = worker.PriorityHigh
+			}
+			job := ZipJob{FS: store, SourcePath: storage.Name(relativePath), Ignore: m}
+			var err error
+			t, err = held.enqueue(wp, job, relativePath, client, worker.Options{Client: clientKey(r), Priority: priority})
+			if err != nil {
+				metrics.Rejected.WithLabelValues("zip").Inc()
+				w.Header().Set("Retry-After", "10")
+				http.Error(w, "Server busy, please try again", http.StatusServiceUnavailable)
+				return
+			}
 		}
 
+		// The ticket does not expire while a request is waiting on it.
+		t.expire.Stop()
+
+		// Browsers get a page showing their place in line, which reloads
+		// with the ticket until the job starts; other clients simply wait.
+		browser := strings.Contains(r.Header.Get("Accept"), "text/html")
+		var report <-chan time.Time
+		if browser {
+			report = time.After(queueReportDelay)
+		}
 		select {
-		case <-doneChan:
+		case <-t.ticket.Started():
+		case <-t.ticket.Done():
+		case <-report:
+			if position := t.ticket.Position(); position > 0 {
+				t.expire.Reset(heldTicketTTL)
+				renderQueued(w, r, relativePath, position, t.token)
+				return
+			}
 		case <-ctx.Done():
-			log.Printf("Client disconnected during zip: %s", relativePath)
+			if browser {
+				t.expire.Reset(heldTicketTTL)
+			} else {
+				held.drop(t)
+				logger.Info("Client left the zip queue", "path", relativePath)
+			}
+			return
+		}
+
+		var written int64
+		c := zipClaim{ctx: ctx, w: limiter.Writer(r, w), written: &written, done: make(chan error, 1)}
+		var err error
+		select {
+		case t.claim <- c:
+			err = <-c.done
+		case <-t.ticket.Done():
+			err = t.ticket.Err()
 		}
-		<-doneChan
+		held.drop(t)
+
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			logger.Warn("Zip timed out", "path", relativePath, "sent", written)
+		case ctx.Err() != nil:
+			logger.Info("Client disconnected during zip", "path", relativePath, "sent", written)
+		case errors.Is(err, context.Canceled) || errors.Is(err, worker.ErrCancelled):
+			// The ticket expired or was withdrawn before this request
+			// could claim it.
+			w.Header().Set("Retry-After", strconv.Itoa(queueRetrySeconds))
+			http.Error(w, "Download expired, please try again", http.StatusServiceUnavailable)
+		case err != nil:
+			logger.Error("Zip error", "path", relativePath, "err", err)
+		}
+		if written > 0 {
+			auditLog.Record(r, audit.ActionZip, relativePath, written, "")
+		}
+	}
+}
+
+// renderQueued tells a browser where it stands in the zip queue. The page
+// reloads with the ticket, so the job keeps its place as long as the
+// browser keeps coming back.
+func renderQueued(w http.ResponseWriter, r *http.Request, relativePath string, position int, token string) {
+	w.Header().Set("Retry-After", strconv.Itoa(queueRetrySeconds))
+	w.Header().Set("X-Queue-Position", strconv.Itoa(position))
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	t, err := template.New("queued").Parse(templates.QueuedTpl)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Template parse error", "err", err)
+		return
+	}
+	data := struct {
+		Path     string
+		Position int
+		Retry    int
+		Refresh  string
+	}{relativePath, position, queueRetrySeconds, "/zip?" + url.Values{"path": {relativePath}, "ticket": {token}}.Encode()}
+	if err := t.Execute(w, data); err != nil {
+		logging.FromContext(r.Context()).Error("Template execution error", "err", err)
+	}
+}
+
+// clientKey identifies whose turn a job belongs to: the user if a handler
+// verified their credentials, otherwise the client IP.
+func clientKey(r *http.Request) string {
+	if user := activity.User(r); user != "" {
+		return "user:" + user
 	}
+	return activity.ClientAddr(r)
 }
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fileshare/internal/index"
+	"fileshare/internal/worker"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// zipEntries reads the archive in rec and returns its entries' contents by
+// name.
+func zipEntries(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = string(data)
+	}
+	return entries
+}
+
+func TestZipFolder(t *testing.T) {
+	m := newTestMatcher(t)
+	handler := ZipHandlerFactory(newTestStore(), newTestPool(t, 1), index.New(t.TempDir(), "", m), m, nil, nil)
+
+	tests := []struct {
+		path     string
+		fileName string
+		entries  []string
+	}{
+		{"/docs", "docs.zip", []string{"docs/a.txt", "docs/sub/b.txt"}},
+		// A store without a directory on disk has no name of its own.
+		{"/", "fileshare.zip", []string{"fileshare/docs/a.txt", "fileshare/docs/sub/b.txt", "fileshare/top.txt"}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/zip?path="+tt.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("zip %s: status = %d, body %q", tt.path, rec.Code, rec.Body)
+		}
+		if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, `"`+tt.fileName+`"`) {
+			t.Errorf("zip %s: Content-Disposition = %q, want %s", tt.path, cd, tt.fileName)
+		}
+		entries := zipEntries(t, rec)
+		var names []string
+		for name := range entries {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, tt.entries) {
+			t.Errorf("zip %s: entries %q, want %q", tt.path, names, tt.entries)
+		}
+	}
+}
+
+func TestZipQueuedBrowserKeepsTicket(t *testing.T) {
+	m := newTestMatcher(t)
+	pool := newTestPool(t, 1)
+	handler := ZipHandlerFactory(newTestStore(), pool, index.New(t.TempDir(), "", m), m, nil, nil)
+
+	// Keep the only worker busy so the zip has to wait.
+	release := make(chan struct{})
+	busy, err := pool.Enqueue(context.Background(), worker.JobFunc(func(ctx context.Context) error {
+		<-release
+		return nil
+	}), worker.Options{Client: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-busy.Started()
+
+	req := httptest.NewRequest(http.MethodGet, "/zip?path=/docs", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("X-Queue-Position") != "1" {
+		t.Fatalf("queued: status = %d, position %q", rec.Code, rec.Header().Get("X-Queue-Position"))
+	}
+	match := regexp.MustCompile(`ticket=([0-9a-f]+)`).FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatalf("queued page has no ticket: %s", rec.Body)
+	}
+
+	// The reload presents the ticket and gets the zip once the worker is
+	// free.
+	close(release)
+	req = httptest.NewRequest(http.MethodGet, "/zip?path=/docs&ticket="+match[1], nil)
+	req.Header.Set("Accept", "text/html")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload: status = %d, body %q", rec.Code, rec.Body)
+	}
+	if got := zipEntries(t, rec)["docs/a.txt"]; got != "hello" {
+		t.Errorf("docs/a.txt = %q, want hello", got)
+	}
+}
+
+func TestHeldTickets(t *testing.T) {
+	pool := newTestPool(t, 1)
+	release := make(chan struct{})
+	busy, err := pool.Enqueue(context.Background(), worker.JobFunc(func(ctx context.Context) error {
+		<-release
+		return nil
+	}), worker.Options{Client: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-busy.Started()
+	defer close(release)
+
+	held := &heldTickets{tickets: map[string]*heldTicket{}}
+	ht, err := held.enqueue(pool, ZipJob{FS: newTestStore(), SourcePath: "docs"}, "/docs", "10.0.0.1", worker.Options{Client: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token only works for the client and folder it was issued for.
+	tests := []struct {
+		token, path, client string
+		found               bool
+	}{
+		{ht.token, "/docs", "10.0.0.1", true},
+		{ht.token, "/docs", "10.0.0.2", false},
+		{ht.token, "/", "10.0.0.1", false},
+		{"unknown", "/docs", "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := held.get(tt.token, tt.path, tt.client); (got != nil) != tt.found {
+			t.Errorf("get(%q, %q, %q) = %v, want found %v", tt.token, tt.path, tt.client, got, tt.found)
+		}
+	}
+	if ht.ticket.Position() != 1 {
+		t.Errorf("position = %d, want 1", ht.ticket.Position())
+	}
+
+	// Dropping a ticket gives up its place in the queue.
+	held.drop(ht)
+	if err := ht.ticket.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("dropped job: Err = %v, want context.Canceled", err)
+	}
+	if held.get(ht.token, "/docs", "10.0.0.1") != nil {
+		t.Error("dropped ticket still held")
+	}
+}
@@ -0,0 +1,249 @@
+// Package ignore
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FileName is the ignore file read from the root of the share.
+const FileName = ".fileshareignore"
+
+type rule struct {
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Matcher decides which paths are hidden from listing, zipping, search and
+// direct downloads. A nil Matcher hides dotfiles and .partial uploads.
+type Matcher struct {
+	rules      []rule
+	showHidden bool
+	// private holds the server's own files that live inside the share,
+	// by path relative to the root. No rule can expose them.
+	private map[string]bool
+	// rotated holds logs whose numbered rotations (log.1, log.2, ...)
+	// are private too.
+	rotated map[string]bool
+}
+
+// New builds a Matcher from root/.fileshareignore followed by extra patterns,
+// both in gitignore syntax. Later rules win, so patterns can override the file.
+func New(root string, patterns []string, showHidden bool) (*Matcher, error) {
+	m := &Matcher{showHidden: showHidden}
+
+	f, err := os.Open(filepath.Join(root, FileName))
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			if err := m.add(scanner.Text()); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", FileName, line, err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, p := range patterns {
+		if err := m.add(p); err != nil {
+			return nil, fmt.Errorf("-ignore %q: %w", p, err)
+		}
+	}
+	return m, nil
+}
+
+// Hide excludes the files at the given absolute paths, along with their
+// ".tmp" siblings written while saving, if they lie under root. Empty paths
+// are skipped.
+func (m *Matcher) Hide(root string, paths ...string) {
+	for _, p := range paths {
+		if rel, ok := relativeTo(root, p); ok {
+			if m.private == nil {
+				m.private = map[string]bool{}
+			}
+			m.private[rel] = true
+			m.private[rel+".tmp"] = true
+		}
+	}
+}
+
+// HideRotated excludes a log at an absolute path under root together with
+// its numbered rotations, path.1, path.2 and so on.
+func (m *Matcher) HideRotated(root, path string) {
+	if rel, ok := relativeTo(root, path); ok {
+		m.Hide(root, path)
+		if m.rotated == nil {
+			m.rotated = map[string]bool{}
+		}
+		m.rotated[rel] = true
+	}
+}
+
+// relativeTo returns p relative to root in slash form, if p is under root.
+func relativeTo(root, p string) (string, bool) {
+	if p == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// isPrivate reports whether rel is a file excluded with Hide or a rotation
+// of a log excluded with HideRotated.
+func (m *Matcher) isPrivate(rel string) bool {
+	if m.private[rel] {
+		return true
+	}
+	if i := strings.LastIndexByte(rel, '.'); i > 0 && m.rotated[rel[:i]] {
+		_, err := strconv.Atoi(rel[i+1:])
+		return err == nil
+	}
+	return false
+}
+
+func (m *Matcher) add(pattern string) error {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+
+	var r rule
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if strings.Contains(pattern, "/") {
+		r.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile("^" + globToRegexp(pattern) + "$")
+	if err != nil {
+		return err
+	}
+	r.re = re
+	m.rules = append(m.rules, r)
+	return nil
+}
+
+// globToRegexp translates gitignore wildcards: * and ? stay within a path
+// segment, ** crosses segments and [...] is a character class.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Private reports whether urlPath is one of the files excluded with Hide.
+// Unlike Ignored it does not treat .partial uploads as hidden.
+func (m *Matcher) Private(urlPath string) bool {
+	return m != nil && m.isPrivate(strings.Trim(filepath.ToSlash(urlPath), "/"))
+}
+
+// Ignored reports whether urlPath ("/a/b.txt") is excluded. A path is also
+// excluded when any of its parent directories is.
+func (m *Matcher) Ignored(urlPath string, isDir bool) bool {
+	rel := strings.Trim(filepath.ToSlash(urlPath), "/")
+	if rel == "" || rel == "." {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		last := i == len(parts)-1
+		if m.match(strings.Join(parts[:i+1], "/"), parts[i], !last || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// IgnoredName checks a single entry inside the directory at dirURLPath.
+func (m *Matcher) IgnoredName(dirURLPath, name string, isDir bool) bool {
+	rel := strings.Trim(filepath.ToSlash(dirURLPath), "/")
+	if rel != "" {
+		rel += "/"
+	}
+	return m.match(rel+name, name, isDir)
+}
+
+func (m *Matcher) match(rel, name string, isDir bool) bool {
+	// In-progress uploads are never exposed.
+	if strings.HasSuffix(name, ".partial") {
+		return true
+	}
+	if m == nil {
+		return strings.HasPrefix(name, ".")
+	}
+	if m.isPrivate(rel) {
+		return true
+	}
+
+	ignored := !m.showHidden && strings.HasPrefix(name, ".")
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		target := name
+		if r.anchored {
+			target = rel
+		}
+		if r.re.MatchString(target) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,131 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		name       string
+		patterns   []string
+		showHidden bool
+		path       string
+		isDir      bool
+		want       bool
+	}{
+		{name: "plain file", path: "/docs/a.txt"},
+		{name: "dotfile hidden", path: "/.env", want: true},
+		{name: "dotfile shown", showHidden: true, path: "/.env"},
+		{name: "inside hidden dir", path: "/.git/config", want: true},
+		{name: "partial always hidden", showHidden: true, path: "/docs/.a.txt.partial", want: true},
+		{name: "glob on name", patterns: []string{"*.log"}, path: "/var/app.log", want: true},
+		{name: "glob stays in segment", patterns: []string{"docs/*.txt"}, path: "/docs/sub/a.txt"},
+		{name: "anchored", patterns: []string{"/build"}, path: "/build", isDir: true, want: true},
+		{name: "anchored elsewhere", patterns: []string{"/build"}, path: "/src/build", isDir: true},
+		{name: "unanchored anywhere", patterns: []string{"build"}, path: "/src/build", isDir: true, want: true},
+		{name: "dir only skips files", patterns: []string{"tmp/"}, path: "/tmp"},
+		{name: "dir only hides contents", patterns: []string{"tmp/"}, path: "/tmp/x.bin", want: true},
+		{name: "double star", patterns: []string{"**/cache/*.bin"}, path: "/a/b/cache/x.bin", want: true},
+		{name: "double star at root", patterns: []string{"**/cache/*.bin"}, path: "/cache/x.bin", want: true},
+		{name: "character class", patterns: []string{"img[0-9].png"}, path: "/img7.png", want: true},
+		{name: "negated class", patterns: []string{"img[!0-9].png"}, path: "/img7.png"},
+		{name: "question mark", patterns: []string{"?.txt"}, path: "/ab.txt"},
+		{name: "later rule wins", patterns: []string{"*.log", "!keep.log"}, path: "/keep.log"},
+		{name: "negation can show dotfile", patterns: []string{"!.well-known/"}, path: "/.well-known", isDir: true},
+		{name: "escaped bang", patterns: []string{`\!important`}, path: "/!important", want: true},
+		{name: "root never ignored", patterns: []string{"*"}, path: "/", isDir: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(t.TempDir(), tt.patterns, tt.showHidden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Ignored(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilMatcher(t *testing.T) {
+	var m *Matcher
+	for path, want := range map[string]bool{"/a.txt": false, "/.env": true, "/.a.txt.partial": true} {
+		if got := m.Ignored(path, false); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if m.Private("/a.txt") {
+		t.Error("nil matcher has private files")
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte("# comment\n*.iso\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Patterns given on the command line come after the file and win.
+	m, err := New(root, []string{"!keep.iso"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Ignored("/big.iso", false) || m.Ignored("/keep.iso", false) {
+		t.Errorf("big.iso ignored = %v, keep.iso ignored = %v", m.Ignored("/big.iso", false), m.Ignored("/keep.iso", false))
+	}
+	if !m.Ignored("/"+FileName, false) {
+		t.Error("the ignore file itself is listed")
+	}
+}
+
+func TestHide(t *testing.T) {
+	root := t.TempDir()
+	m, err := New(root, []string{"!*.gob"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Hide(root, filepath.Join(root, "state", "idx.gob"), filepath.Join(filepath.Dir(root), "outside.gob"), "")
+
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		// No rule can expose a hidden file or its temporary sibling.
+		{"/state/idx.gob", true},
+		{"/state/idx.gob.tmp", true},
+		{"/state/other.gob", false},
+		{"/outside.gob", false},
+	}
+	for _, tt := range tests {
+		if got := m.Ignored(tt.path, false); got != tt.ignored {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.ignored)
+		}
+		if got := m.Private(tt.path); got != tt.ignored {
+			t.Errorf("Private(%q) = %v, want %v", tt.path, got, tt.ignored)
+		}
+	}
+}
+
+func TestHideRotated(t *testing.T) {
+	root := t.TempDir()
+	m, err := New(root, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.HideRotated(root, filepath.Join(root, "logs", "access.log"))
+
+	for path, want := range map[string]bool{
+		"/logs/access.log":     true,
+		"/logs/access.log.1":   true,
+		"/logs/access.log.12":  true,
+		"/logs/access.log.old": false,
+		"/logs/access.log1":    false,
+		"/logs/other.log.1":    false,
+	} {
+		if got := m.Ignored(path, false); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	contentSnapshotVersion = 1
+	maxTokenLen            = 64
+	maxSnippets            = 3
+	snippetWidth           = 160
+)
+
+// Snippet is a matching line from a document. Matches are byte ranges in Text.
+type Snippet struct {
+	Line    int      `json:"line"`
+	Text    string   `json:"text"`
+	Matches [][2]int `json:"matches"`
+}
+
+// ContentHit is a document that contains every query term.
+type ContentHit struct {
+	Path     string    `json:"path"`
+	Snippets []Snippet `json:"snippets"`
+}
+
+type contentDoc struct {
+	ModTime time.Time
+	Size    int64
+	Tokens  []string
+}
+
+type contentSnapshot struct {
+	Version int
+	Root    string
+	Docs    map[string]contentDoc
+}
+
+// ContentIndex is an inverted index over the text files known to an Index.
+type ContentIndex struct {
+	idx       *Index
+	root      string
+	storePath string
+	maxSize   int64
+
+	mu       sync.RWMutex
+	docs     map[string]contentDoc
+	postings map[string]map[string]struct{}
+	dirty    bool
+
+	done chan struct{}
+}
+
+// NewContentIndex creates a content indexer for text files up to maxSize
+// bytes. The inverted index is persisted at storePath.
+func NewContentIndex(idx *Index, storePath string, maxSize int64) *ContentIndex {
+	return &ContentIndex{
+		idx:       idx,
+		root:      idx.root,
+		storePath: storePath,
+		maxSize:   maxSize,
+		docs:      map[string]contentDoc{},
+		postings:  map[string]map[string]struct{}{},
+		done:      make(chan struct{}),
+	}
+}
+
+// Start loads the stored index and keeps it in sync with the file index.
+func (ci *ContentIndex) Start() {
+	if err := ci.load(); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Content index ignored", "err", err)
+	}
+	sub := ci.idx.Subscribe(4096)
+	go ci.run(sub)
+}
+
+// Close stops indexing and writes the index to disk.
+func (ci *ContentIndex) Close() {
+	close(ci.done)
+	ci.save()
+}
+
+func (ci *ContentIndex) run(sub *Subscription) {
+	select {
+	case <-ci.idx.WaitReady():
+	case <-ci.done:
+		return
+	}
+	sub.Resync()
+	ci.reconcile()
+
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case c := <-sub.C:
+			if sub.Resync() {
+				ci.reconcile()
+				continue
+			}
+			ci.apply(c)
+		case <-ticker.C:
+			ci.save()
+		case <-ci.done:
+			return
+		}
+	}
+}
+
+// reconcile indexes new or modified files and drops documents that are gone.
+func (ci *ContentIndex) reconcile() {
+	start := time.Now()
+	current := map[string]Entry{}
+	ci.idx.Files(func(e Entry) {
+		if e.Size <= ci.maxSize {
+			current[e.Path] = e
+		}
+	})
+
+	ci.mu.Lock()
+	for p := range ci.docs {
+		if _, ok := current[p]; !ok {
+			ci.drop(p)
+		}
+	}
+	ci.mu.Unlock()
+
+	updated := 0
+	for p, e := range current {
+		ci.mu.RLock()
+		doc, ok := ci.docs[p]
+		ci.mu.RUnlock()
+		if ok && doc.ModTime.Equal(e.ModTime) && doc.Size == e.Size {
+			continue
+		}
+		ci.update(p)
+		updated++
+	}
+
+	ci.mu.RLock()
+	docs := len(ci.docs)
+	ci.mu.RUnlock()
+	slog.Info("Content index ready", "documents", docs, "updated", updated, "took", time.Since(start).Round(time.Millisecond))
+	ci.save()
+}
+
+func (ci *ContentIndex) apply(c Change) {
+	if c.Removed {
+		ci.mu.Lock()
+		if c.IsDir {
+			prefix := c.Path + "/"
+			for p := range ci.docs {
+				if strings.HasPrefix(p, prefix) {
+					ci.drop(p)
+				}
+			}
+		} else {
+			ci.drop(c.Path)
+		}
+		ci.mu.Unlock()
+		return
+	}
+	if !c.IsDir {
+		ci.update(c.Path)
+	}
+}
+
+// update re-reads and re-tokenizes one document.
+func (ci *ContentIndex) update(urlPath string) {
+	absPath := filepath.Join(ci.root, filepath.FromSlash(urlPath))
+	info, err := os.Stat(absPath)
+	if err != nil || info.IsDir() || info.Size() > ci.maxSize {
+		ci.mu.Lock()
+		ci.drop(urlPath)
+		ci.mu.Unlock()
+		return
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return
+	}
+
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.drop(urlPath)
+	if !isText(data) {
+		return
+	}
+	doc := contentDoc{ModTime: info.ModTime(), Size: info.Size(), Tokens: uniqueTokens(string(data))}
+	ci.add(urlPath, doc)
+}
+
+// add and drop maintain postings. Caller must hold mu.
+func (ci *ContentIndex) add(urlPath string, doc contentDoc) {
+	ci.docs[urlPath] = doc
+	for _, t := range doc.Tokens {
+		set := ci.postings[t]
+		if set == nil {
+			set = map[string]struct{}{}
+			ci.postings[t] = set
+		}
+		set[urlPath] = struct{}{}
+	}
+	ci.dirty = true
+}
+
+func (ci *ContentIndex) drop(urlPath string) {
+	doc, ok := ci.docs[urlPath]
+	if !ok {
+		return
+	}
+	for _, t := range doc.Tokens {
+		delete(ci.postings[t], urlPath)
+		if len(ci.postings[t]) == 0 {
+			delete(ci.postings, t)
+		}
+	}
+	delete(ci.docs, urlPath)
+	ci.dirty = true
+}
+
+// Search returns up to limit documents containing every term in query, with
+// snippets of the matching lines.
+func (ci *ContentIndex) Search(query string, limit int) []ContentHit {
+	terms := uniqueTokens(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	ci.mu.RLock()
+	sort.Slice(terms, func(i, j int) bool { return len(ci.postings[terms[i]]) < len(ci.postings[terms[j]]) })
+	var paths []string
+	for p := range ci.postings[terms[0]] {
+		match := true
+		for _, t := range terms[1:] {
+			if _, ok := ci.postings[t][p]; !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			paths = append(paths, p)
+		}
+	}
+	ci.mu.RUnlock()
+
+	sort.Strings(paths)
+	if limit > 0 && len(paths) > limit {
+		paths = paths[:limit]
+	}
+	hits := make([]ContentHit, 0, len(paths))
+	for _, p := range paths {
+		hits = append(hits, ContentHit{Path: p, Snippets: ci.snippets(p, terms)})
+	}
+	return hits
+}
+
+// snippets re-reads the document and returns the first lines mentioning a term.
+func (ci *ContentIndex) snippets(urlPath string, terms []string) []Snippet {
+	f, err := os.Open(filepath.Join(ci.root, filepath.FromSlash(urlPath)))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var out []Snippet
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), int(ci.maxSize)+1)
+	for lineNo := 1; scanner.Scan() && len(out) < maxSnippets; lineNo++ {
+		line := scanner.Text()
+		lower := strings.ToLower(line)
+		if len(lower) != len(line) {
+			// Case folding changed byte offsets; match on the raw line instead.
+			lower = line
+		}
+		var matches [][2]int
+		for _, t := range terms {
+			for from := 0; ; {
+				i := strings.Index(lower[from:], t)
+				if i < 0 {
+					break
+				}
+				start := from + i
+				matches = append(matches, [2]int{start, start + len(t)})
+				from = start + len(t)
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		out = append(out, trimSnippet(lineNo, line, matches))
+	}
+	return out
+}
+
+// trimSnippet cuts long lines down to a window around the first match.
+func trimSnippet(lineNo int, line string, matches [][2]int) Snippet {
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+	start := 0
+	if len(line) > snippetWidth {
+		start = matches[0][0] - snippetWidth/4
+		if start < 0 {
+			start = 0
+		}
+		for start > 0 && !utf8.RuneStart(line[start]) {
+			start--
+		}
+	}
+	end := len(line)
+	if end-start > snippetWidth {
+		end = start + snippetWidth
+		for end < len(line) && !utf8.RuneStart(line[end]) {
+			end++
+		}
+	}
+
+	s := Snippet{Line: lineNo, Text: line[start:end]}
+	last := 0
+	for _, m := range matches {
+		if m[0] < start || m[1] > end || m[0]-start < last {
+			continue
+		}
+		s.Matches = append(s.Matches, [2]int{m[0] - start, m[1] - start})
+		last = m[1] - start
+	}
+	return s
+}
+
+// isText rejects files that are not valid UTF-8 or contain NUL bytes.
+func isText(data []byte) bool {
+	head := data
+	if len(head) > 8192 {
+		head = head[:8192]
+	}
+	return !bytes.Contains(head, []byte{0}) && utf8.Valid(data)
+}
+
+func uniqueTokens(s string) []string {
+	seen := map[string]struct{}{}
+	var out []string
+	for _, f := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(f) < 2 || len(f) > maxTokenLen {
+			continue
+		}
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		out = append(out, f)
+	}
+	return out
+}
+
+func (ci *ContentIndex) load() error {
+	f, err := os.Open(ci.storePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap contentSnapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != contentSnapshotVersion || snap.Root != ci.root {
+		return nil
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	for p, doc := range snap.Docs {
+		ci.add(p, doc)
+	}
+	ci.dirty = false
+	slog.Info("Content index loaded", "documents", len(snap.Docs))
+	return nil
+}
+
+func (ci *ContentIndex) save() {
+	ci.mu.Lock()
+	if !ci.dirty {
+		ci.mu.Unlock()
+		return
+	}
+	snap := contentSnapshot{Version: contentSnapshotVersion, Root: ci.root, Docs: make(map[string]contentDoc, len(ci.docs))}
+	for p, doc := range ci.docs {
+		snap.Docs[p] = doc
+	}
+	ci.dirty = false
+	ci.mu.Unlock()
+
+	tmp := ci.storePath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		slog.Error("Content index save failed", "err", err)
+		return
+	}
+	if err := gob.NewEncoder(f).Encode(snap); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		slog.Error("Content index save failed", "err", err)
+		return
+	}
+	f.Close()
+	if err := os.Rename(tmp, ci.storePath); err != nil {
+		slog.Error("Content index save failed", "err", err)
+	}
+}
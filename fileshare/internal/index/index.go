@@ -0,0 +1,680 @@
+// Package index
+package index
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"fileshare/internal/ignore"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const snapshotVersion = 1
+
+// Entry is a file or directory in the index. Paths are URL style ("/a/b.txt").
+// For directories Size and Files are recursive totals.
+type Entry struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	Files   int       `json:"files"`
+	ModTime time.Time `json:"modTime"`
+
+	lowerName string
+}
+
+// Change describes an entry that was added, modified or removed.
+type Change struct {
+	Path    string
+	IsDir   bool
+	Removed bool
+}
+
+// Subscription delivers index changes. Changes are dropped rather than
+// blocking the index when C is full; Resync reports when that happened.
+type Subscription struct {
+	C    chan Change
+	lost atomic.Bool
+}
+
+// Resync reports whether changes were dropped since the last call, in which
+// case the subscriber should reconcile against the whole index.
+func (s *Subscription) Resync() bool {
+	return s.lost.Swap(false)
+}
+
+type snapshot struct {
+	Version int
+	Root    string
+	Entries []Entry
+}
+
+// Index keeps an in-memory view of the shared tree, updated by fsnotify.
+type Index struct {
+	root         string
+	snapshotPath string
+	ignore       *ignore.Matcher
+
+	mu       sync.RWMutex
+	entries  map[string]*Entry
+	children map[string]map[string]struct{}
+	ready    bool
+	readyC   chan struct{}
+	dirty    bool
+	subs     []*Subscription
+
+	watcher  *fsnotify.Watcher
+	watchErr sync.Once
+	done     chan struct{}
+}
+
+// New creates an index for root, leaving out paths excluded by m. If
+// snapshotPath is set the index is loaded from and saved to that file so
+// restarts only rescan what changed.
+func New(root string, snapshotPath string, m *ignore.Matcher) *Index {
+	if snapshotPath != "" {
+		if abs, err := filepath.Abs(snapshotPath); err == nil {
+			snapshotPath = abs
+		}
+	}
+	idx := &Index{
+		root:         root,
+		snapshotPath: snapshotPath,
+		ignore:       m,
+		readyC:       make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	idx.reset()
+	return idx
+}
+
+func (idx *Index) reset() {
+	idx.entries = map[string]*Entry{
+		"/": {Path: "/", Name: "/", IsDir: true},
+	}
+	idx.children = map[string]map[string]struct{}{}
+}
+
+// Start loads the snapshot, then scans and watches the tree in the background.
+func (idx *Index) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	idx.watcher = watcher
+
+	if idx.snapshotPath != "" {
+		if err := idx.load(); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Index snapshot ignored", "err", err)
+			idx.mu.Lock()
+			idx.reset()
+			idx.mu.Unlock()
+		}
+	}
+
+	go idx.watch()
+	go func() {
+		start := time.Now()
+		idx.scan(idx.root)
+		idx.mu.Lock()
+		idx.ready = true
+		root := *idx.entries["/"]
+		idx.mu.Unlock()
+		close(idx.readyC)
+		slog.Info("Index ready", "files", root.Files, "bytes", root.Size, "took", time.Since(start).Round(time.Millisecond))
+		idx.save()
+	}()
+	if idx.snapshotPath != "" {
+		go idx.saveLoop(5 * time.Minute)
+	}
+	return nil
+}
+
+// Close stops watching and writes the snapshot.
+func (idx *Index) Close() error {
+	if idx.watcher == nil {
+		return nil
+	}
+	close(idx.done)
+	err := idx.watcher.Close()
+	idx.save()
+	return err
+}
+
+// Enabled reports whether Start succeeded. A disabled index never becomes
+// ready, so callers should not wait on it.
+func (idx *Index) Enabled() bool {
+	return idx.watcher != nil
+}
+
+// Ready reports whether the initial scan has finished.
+func (idx *Index) Ready() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.ready
+}
+
+// WaitReady returns a channel that is closed once the initial scan finishes.
+func (idx *Index) WaitReady() <-chan struct{} {
+	return idx.readyC
+}
+
+// Subscribe registers for change notifications with the given buffer size.
+func (idx *Index) Subscribe(buffer int) *Subscription {
+	sub := &Subscription{C: make(chan Change, buffer)}
+	idx.mu.Lock()
+	idx.subs = append(idx.subs, sub)
+	idx.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe stops delivering changes to sub.
+func (idx *Index) Unsubscribe(sub *Subscription) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for i, s := range idx.subs {
+		if s == sub {
+			idx.subs = append(idx.subs[:i], idx.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Files calls fn for every indexed file. fn runs under the read lock and must
+// not call back into the index.
+func (idx *Index) Files(fn func(Entry)) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for _, e := range idx.entries {
+		if !e.IsDir {
+			fn(*e)
+		}
+	}
+}
+
+// Stat returns the entry for urlPath.
+func (idx *Index) Stat(urlPath string) (Entry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	e, ok := idx.entries[cleanURLPath(urlPath)]
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// List returns the direct children of the directory at urlPath.
+func (idx *Index) List(urlPath string) []Entry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	kids := idx.children[cleanURLPath(urlPath)]
+	out := make([]Entry, 0, len(kids))
+	for p := range kids {
+		out = append(out, *idx.entries[p])
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// Search returns up to limit entries whose name contains every term in query.
+func (idx *Index) Search(query string, limit int) []Entry {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	idx.mu.RLock()
+	var out []Entry
+	for p, e := range idx.entries {
+		if p == "/" {
+			continue
+		}
+		match := true
+		for _, t := range terms {
+			if !strings.Contains(e.lowerName, t) {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, *e)
+		}
+	}
+	idx.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].IsDir != out[j].IsDir {
+			return out[i].IsDir
+		}
+		return out[i].Path < out[j].Path
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Recent returns up to limit files ordered by modification time, newest first.
+func (idx *Index) Recent(limit int) []Entry {
+	if limit <= 0 {
+		return nil
+	}
+	h := &byModTime{}
+	idx.mu.RLock()
+	for _, e := range idx.entries {
+		if e.IsDir {
+			continue
+		}
+		if h.Len() < limit {
+			heap.Push(h, *e)
+		} else if e.ModTime.After((*h)[0].ModTime) {
+			(*h)[0] = *e
+			heap.Fix(h, 0)
+		}
+	}
+	idx.mu.RUnlock()
+
+	out := make([]Entry, h.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(h).(Entry)
+	}
+	return out
+}
+
+// byModTime is a min-heap on ModTime used to keep the newest N files.
+type byModTime []Entry
+
+func (h byModTime) Len() int           { return len(h) }
+func (h byModTime) Less(i, j int) bool { return h[i].ModTime.Before(h[j].ModTime) }
+func (h byModTime) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *byModTime) Push(x any)        { *h = append(*h, x.(Entry)) }
+func (h *byModTime) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func (idx *Index) urlPath(absPath string) (string, bool) {
+	rel, err := filepath.Rel(idx.root, absPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return cleanURLPath(filepath.ToSlash(rel)), true
+}
+
+func cleanURLPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// put inserts or updates a single entry and propagates size changes upward.
+// Caller must hold mu.
+func (idx *Index) put(p string, info os.FileInfo) {
+	e, exists := idx.entries[p]
+	if exists && e.IsDir != info.IsDir() {
+		idx.remove(p)
+		exists = false
+	}
+
+	if !exists {
+		e = &Entry{Path: p, Name: info.Name(), IsDir: info.IsDir(), lowerName: strings.ToLower(info.Name())}
+		idx.entries[p] = e
+		parent := path.Dir(p)
+		if idx.children[parent] == nil {
+			idx.children[parent] = map[string]struct{}{}
+		}
+		idx.children[parent][p] = struct{}{}
+		if !e.IsDir {
+			idx.propagate(p, 0, 1)
+		}
+	}
+
+	// Directory mtimes are only recorded once scan has read their contents.
+	if e.IsDir {
+		if !exists {
+			idx.notify(Change{Path: p, IsDir: true})
+		}
+		idx.dirty = true
+		return
+	}
+	if exists && e.ModTime.Equal(info.ModTime()) && e.Size == info.Size() {
+		return
+	}
+	e.ModTime = info.ModTime()
+	if e.Size != info.Size() {
+		idx.propagate(p, info.Size()-e.Size, 0)
+		e.Size = info.Size()
+	}
+	idx.dirty = true
+	idx.notify(Change{Path: p})
+}
+
+// remove deletes an entry and everything below it. Caller must hold mu.
+func (idx *Index) remove(p string) {
+	e, ok := idx.entries[p]
+	if !ok || p == "/" {
+		return
+	}
+	files := 1
+	if e.IsDir {
+		files = e.Files
+		idx.dropSubtree(p)
+	}
+	idx.propagate(p, -e.Size, -files)
+	delete(idx.entries, p)
+	delete(idx.children[path.Dir(p)], p)
+	idx.dirty = true
+	idx.notify(Change{Path: p, IsDir: e.IsDir, Removed: true})
+}
+
+// notify fans a change out to subscribers without blocking. Caller must hold mu.
+func (idx *Index) notify(c Change) {
+	for _, sub := range idx.subs {
+		select {
+		case sub.C <- c:
+		default:
+			sub.lost.Store(true)
+		}
+	}
+}
+
+func (idx *Index) dropSubtree(p string) {
+	for child := range idx.children[p] {
+		if idx.entries[child].IsDir {
+			idx.dropSubtree(child)
+		}
+		delete(idx.entries, child)
+	}
+	delete(idx.children, p)
+}
+
+// propagate adds the deltas to every ancestor directory of p.
+func (idx *Index) propagate(p string, dSize int64, dFiles int) {
+	for p != "/" {
+		p = path.Dir(p)
+		if dir, ok := idx.entries[p]; ok {
+			dir.Size += dSize
+			dir.Files += dFiles
+		}
+	}
+}
+
+// scan reconciles the directory at absDir and everything below it with disk.
+// Directories whose mtime matches the snapshot skip the ReadDir and only have
+// their known entries re-checked.
+func (idx *Index) scan(absDir string) {
+	p, ok := idx.urlPath(absDir)
+	if !ok {
+		return
+	}
+	idx.addWatch(absDir)
+
+	info, err := os.Stat(absDir)
+	if err != nil {
+		idx.mu.Lock()
+		idx.remove(p)
+		idx.mu.Unlock()
+		return
+	}
+
+	idx.mu.RLock()
+	known, wasKnown := idx.entries[p]
+	unchanged := wasKnown && known.ModTime.Equal(info.ModTime())
+	var names []string
+	if unchanged {
+		for child := range idx.children[p] {
+			names = append(names, path.Base(child))
+		}
+	}
+	idx.mu.RUnlock()
+
+	if !unchanged {
+		dirEntries, err := os.ReadDir(absDir)
+		if err != nil {
+			return
+		}
+		present := make(map[string]struct{}, len(dirEntries))
+		for _, de := range dirEntries {
+			if idx.ignore.IgnoredName(p, de.Name(), de.IsDir()) {
+				continue
+			}
+			names = append(names, de.Name())
+			present[path.Join(p, de.Name())] = struct{}{}
+		}
+		idx.mu.Lock()
+		for child := range idx.children[p] {
+			if _, ok := present[child]; !ok {
+				idx.remove(child)
+			}
+		}
+		idx.mu.Unlock()
+	}
+
+	// Stat every child before taking the write lock so listings and
+	// searches are not held up while a large directory is read.
+	type child struct {
+		info os.FileInfo
+		gone bool
+	}
+	children := make([]child, len(names))
+	for i, name := range names {
+		childAbs := filepath.Join(absDir, name)
+		childInfo, err := os.Lstat(childAbs)
+		if err != nil {
+			children[i].gone = true
+			continue
+		}
+		if childInfo.Mode()&os.ModeSymlink != 0 {
+			if childInfo, err = os.Stat(childAbs); err != nil || childInfo.IsDir() {
+				continue
+			}
+		}
+		children[i].info = childInfo
+	}
+
+	var subdirs []string
+	idx.mu.Lock()
+	for i, name := range names {
+		c := children[i]
+		if c.gone {
+			idx.remove(path.Join(p, name))
+			continue
+		}
+		if c.info == nil {
+			continue
+		}
+		idx.put(path.Join(p, name), c.info)
+		if c.info.IsDir() {
+			subdirs = append(subdirs, filepath.Join(absDir, name))
+		}
+	}
+	if dir, ok := idx.entries[p]; ok {
+		dir.ModTime = info.ModTime()
+	} else {
+		idx.put(p, info)
+		idx.entries[p].ModTime = info.ModTime()
+	}
+	idx.mu.Unlock()
+
+	for _, sub := range subdirs {
+		idx.scan(sub)
+	}
+}
+
+func (idx *Index) addWatch(absDir string) {
+	if err := idx.watcher.Add(absDir); err != nil {
+		idx.watchErr.Do(func() {
+			slog.Warn("Index watch limited, some changes need a restart to show up", "err", err)
+		})
+	}
+}
+
+func (idx *Index) watch() {
+	for {
+		select {
+		case ev, ok := <-idx.watcher.Events:
+			if !ok {
+				return
+			}
+			idx.handleEvent(ev)
+		case err, ok := <-idx.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Index watcher error", "err", err)
+		}
+	}
+}
+
+func (idx *Index) handleEvent(ev fsnotify.Event) {
+	if ev.Name == idx.snapshotPath || ev.Name == idx.snapshotPath+".tmp" {
+		return
+	}
+	p, ok := idx.urlPath(ev.Name)
+	if !ok || p == "/" {
+		return
+	}
+
+	if ev.Has(fsnotify.Create) || ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		idx.touchParent(ev.Name)
+	}
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		idx.mu.Lock()
+		idx.remove(p)
+		idx.mu.Unlock()
+		return
+	}
+
+	info, err := os.Stat(ev.Name)
+	if err != nil {
+		idx.mu.Lock()
+		idx.remove(p)
+		idx.mu.Unlock()
+		return
+	}
+	if idx.ignore.Ignored(p, info.IsDir()) {
+		return
+	}
+	if info.IsDir() && ev.Has(fsnotify.Create) {
+		// Files may land before the watch is added, so scan the new directory.
+		idx.mu.Lock()
+		idx.put(p, info)
+		idx.mu.Unlock()
+		idx.scan(ev.Name)
+		return
+	}
+	idx.mu.Lock()
+	idx.put(p, info)
+	idx.mu.Unlock()
+}
+
+// touchParent refreshes the parent directory's mtime so the snapshot stays
+// usable for the incremental startup scan.
+func (idx *Index) touchParent(absPath string) {
+	dir := filepath.Dir(absPath)
+	p, ok := idx.urlPath(dir)
+	if !ok {
+		return
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return
+	}
+	idx.mu.Lock()
+	if e, ok := idx.entries[p]; ok {
+		e.ModTime = info.ModTime()
+		idx.dirty = true
+	}
+	idx.mu.Unlock()
+}
+
+func (idx *Index) load() error {
+	f, err := os.Open(idx.snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion || snap.Root != idx.root {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for i := range snap.Entries {
+		e := snap.Entries[i]
+		e.lowerName = strings.ToLower(e.Name)
+		idx.entries[e.Path] = &e
+		if e.Path == "/" {
+			continue
+		}
+		parent := path.Dir(e.Path)
+		if idx.children[parent] == nil {
+			idx.children[parent] = map[string]struct{}{}
+		}
+		idx.children[parent][e.Path] = struct{}{}
+	}
+	slog.Info("Index snapshot loaded", "entries", len(snap.Entries))
+	return nil
+}
+
+func (idx *Index) save() {
+	if idx.snapshotPath == "" {
+		return
+	}
+	idx.mu.Lock()
+	if !idx.dirty {
+		idx.mu.Unlock()
+		return
+	}
+	snap := snapshot{Version: snapshotVersion, Root: idx.root, Entries: make([]Entry, 0, len(idx.entries))}
+	for _, e := range idx.entries {
+		snap.Entries = append(snap.Entries, *e)
+	}
+	idx.dirty = false
+	idx.mu.Unlock()
+
+	tmp := idx.snapshotPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		slog.Error("Index snapshot save failed", "err", err)
+		return
+	}
+	if err := gob.NewEncoder(f).Encode(snap); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		slog.Error("Index snapshot save failed", "err", err)
+		return
+	}
+	f.Close()
+	if err := os.Rename(tmp, idx.snapshotPath); err != nil {
+		slog.Error("Index snapshot save failed", "err", err)
+	}
+}
+
+func (idx *Index) saveLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			idx.save()
+		case <-idx.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLog writes one line per request in Common, Combined or JSON format.
+type AccessLog struct {
+	format string
+	mu     sync.Mutex
+	out    io.WriteCloser
+}
+
+// NewAccessLog opens path for appending. The file is rotated once it grows
+// past maxSize bytes, keeping up to backups old files (path.1, path.2, ...).
+func NewAccessLog(path, format string, maxSize int64, backups int) (*AccessLog, error) {
+	switch format {
+	case "common", "combined", "json":
+	default:
+		return nil, fmt.Errorf("invalid access log format %q (want common, combined or json)", format)
+	}
+	out, err := newRotatingFile(path, maxSize, backups)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLog{format: format, out: out}, nil
+}
+
+// Log records a finished request.
+func (a *AccessLog) Log(r *http.Request, status int, bytes int64, start time.Time, duration time.Duration) {
+	var line []byte
+	if a.format == "json" {
+		line, _ = json.Marshal(struct {
+			Time       time.Time `json:"time"`
+			RequestID  string    `json:"request_id"`
+			Remote     string    `json:"remote"`
+			Method     string    `json:"method"`
+			URI        string    `json:"uri"`
+			Proto      string    `json:"proto"`
+			Status     int       `json:"status"`
+			Bytes      int64     `json:"bytes"`
+			DurationMS float64   `json:"duration_ms"`
+			Referer    string    `json:"referer,omitempty"`
+			UserAgent  string    `json:"user_agent,omitempty"`
+		}{start, RequestID(r), clientHost(r.RemoteAddr), r.Method, r.RequestURI, r.Proto, status, bytes,
+			float64(duration.Microseconds()) / 1000, r.Referer(), r.UserAgent()})
+		line = append(line, '\n')
+	} else {
+		size := "-"
+		if bytes > 0 {
+			size = strconv.FormatInt(bytes, 10)
+		}
+		line = fmt.Appendf(nil, "%s - - [%s] %q %d %s", clientHost(r.RemoteAddr), start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, status, size)
+		if a.format == "combined" {
+			line = fmt.Appendf(line, " %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+		}
+		line = append(line, '\n')
+	}
+
+	a.mu.Lock()
+	a.out.Write(line)
+	a.mu.Unlock()
+}
+
+// Close closes the underlying file.
+func (a *AccessLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.out.Close()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func newRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize && rf.size > 0 {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	if rf.backups > 0 {
+		for i := rf.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		os.Rename(rf.path, rf.path+".1")
+	} else {
+		os.Remove(rf.path)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	return rf.f.Close()
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxErrors = 50
+
+// ErrorEntry is a warning or error kept for the admin page.
+type ErrorEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	Attrs   string    `json:"attrs,omitempty"`
+}
+
+var recent struct {
+	mu      sync.Mutex
+	entries []ErrorEntry
+}
+
+// RecentErrors returns the latest warnings and errors, newest first.
+func RecentErrors() []ErrorEntry {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+	out := make([]ErrorEntry, len(recent.entries))
+	for i, e := range recent.entries {
+		out[len(out)-1-i] = e
+	}
+	return out
+}
+
+// errorTee passes records through to the wrapped handler and remembers
+// those at Warn and above.
+type errorTee struct {
+	slog.Handler
+	attrs []slog.Attr
+}
+
+func (t *errorTee) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return lvl >= slog.LevelWarn || t.Handler.Enabled(ctx, lvl)
+}
+
+func (t *errorTee) Handle(ctx context.Context, rec slog.Record) error {
+	if rec.Level >= slog.LevelWarn {
+		var b strings.Builder
+		write := func(a slog.Attr) bool {
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%s=%v", a.Key, a.Value)
+			return true
+		}
+		for _, a := range t.attrs {
+			write(a)
+		}
+		rec.Attrs(write)
+
+		recent.mu.Lock()
+		recent.entries = append(recent.entries, ErrorEntry{rec.Time, rec.Level.String(), rec.Message, b.String()})
+		if len(recent.entries) > maxErrors {
+			recent.entries = recent.entries[len(recent.entries)-maxErrors:]
+		}
+		recent.mu.Unlock()
+	}
+	if !t.Handler.Enabled(ctx, rec.Level) {
+		return nil
+	}
+	return t.Handler.Handle(ctx, rec)
+}
+
+func (t *errorTee) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &errorTee{Handler: t.Handler.WithAttrs(attrs), attrs: append(t.attrs[:len(t.attrs):len(t.attrs)], attrs...)}
+}
+
+func (t *errorTee) WithGroup(name string) slog.Handler {
+	return &errorTee{Handler: t.Handler.WithGroup(name), attrs: t.attrs}
+}
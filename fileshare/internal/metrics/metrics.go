@@ -0,0 +1,134 @@
+// Package metrics
+package metrics
+
+import (
+	"fileshare/internal/worker"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fileshare"
+
+var (
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by handler and status code.",
+	}, []string{"handler", "code"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by handler.",
+		Buckets:   []float64{.005, .025, .1, .5, 1, 5, 30, 120, 600},
+	}, []string{"handler"})
+
+	BytesUploaded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Bytes written to disk by chunk uploads.",
+	})
+
+	BytesDownloaded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Bytes sent for file and zip downloads.",
+	})
+
+	ChunkWriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "chunk_write_duration_seconds",
+		Help:      "Time to persist one upload chunk, including fsync.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+
+	ActiveZipJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "zip_jobs_active",
+		Help:      "Zip downloads currently being written.",
+	})
+
+	Rejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejected_requests_total",
+		Help:      "Requests turned away to retry later because a worker pool was full.",
+	}, []string{"handler"})
+
+	PartialsReaped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "partials_reaped_total",
+		Help:      "Stale .partial uploads removed by the cleanup routine.",
+	})
+)
+
+// Handler serves the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterPool exports queue length and capacity for a worker pool.
+func RegisterPool(name string, p *worker.Pool) {
+	labels := prometheus.Labels{"pool": name}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "pool_queue_length",
+		Help:        "Jobs waiting in the worker pool queue.",
+		ConstLabels: labels,
+	}, func() float64 { return float64(p.QueueLength()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "pool_queue_capacity",
+		Help:        "Size of the worker pool queue.",
+		ConstLabels: labels,
+	}, func() float64 { return float64(p.QueueCapacity()) })
+}
+
+// Instrument records request count, status and latency for a handler.
+func Instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		RequestDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+		Requests.WithLabelValues(handler, strconv.Itoa(rec.status)).Inc()
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// CountingWriter counts bytes written through a ResponseWriter.
+type CountingWriter struct {
+	http.ResponseWriter
+	Written int64
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.Written += int64(n)
+	return n, err
+}
+
+func (c *CountingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
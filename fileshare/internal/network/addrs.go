@@ -0,0 +1,170 @@
+package network
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// virtualPrefixes name interfaces that belong to containers, VMs and VPNs
+// rather than a network other devices are on.
+var virtualPrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vmnet", "vboxnet", "lxc", "lxd", "cni", "flannel", "podman",
+	"tun", "tap", "utun", "wg", "tailscale", "zt", "ipsec", "ppp", "awdl", "llw", "anpi", "bridge",
+}
+
+// Address is a local IP address clients may be able to reach the server on.
+type Address struct {
+	IP        net.IP
+	Interface net.Interface
+	// Virtual marks container bridges, VM networks and VPN tunnels.
+	Virtual bool
+	// DefaultRoute marks the address the system uses to reach the internet.
+	DefaultRoute bool
+}
+
+// URL returns scheme://ip:port, bracketing IPv6 addresses.
+func (a Address) URL(scheme, port string) string {
+	return scheme + "://" + net.JoinHostPort(a.IP.String(), port)
+}
+
+// LocalAddresses lists the IPv4 and IPv6 addresses of every interface that
+// is up, best first: real interfaces before virtual ones, the default route
+// first among those, then IPv4 before IPv6 and private before public.
+// Loopback and link-local addresses are left out since they are no use to
+// other devices.
+func LocalAddresses() ([]Address, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	defaults := defaultRouteIPs()
+
+	var addrs []Address
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		virtual := isVirtual(iface)
+		for _, addr := range ifaceAddrs {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			}
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+				continue
+			}
+			addrs = append(addrs, Address{
+				IP:           ip,
+				Interface:    iface,
+				Virtual:      virtual,
+				DefaultRoute: slices.ContainsFunc(defaults, ip.Equal),
+			})
+		}
+	}
+
+	slices.SortStableFunc(addrs, func(a, b Address) int {
+		return cmp.Or(
+			compareBool(!a.Virtual, !b.Virtual),
+			compareBool(a.DefaultRoute, b.DefaultRoute),
+			compareBool(a.IP.To4() != nil, b.IP.To4() != nil),
+			compareBool(a.IP.IsPrivate(), b.IP.IsPrivate()),
+		)
+	})
+	return addrs, nil
+}
+
+// SelectAddresses picks the addresses to serve on. Each selector is an
+// interface name or an IP address. Without selectors every non-virtual
+// address is used, or every address if there are only virtual ones.
+func SelectAddresses(all []Address, selectors []string) ([]Address, error) {
+	if len(selectors) == 0 {
+		real := slices.DeleteFunc(slices.Clone(all), func(a Address) bool { return a.Virtual })
+		if len(real) == 0 {
+			return all, nil
+		}
+		return real, nil
+	}
+
+	var chosen []Address
+	for _, sel := range selectors {
+		ip := net.ParseIP(sel)
+		n := len(chosen)
+		for _, a := range all {
+			if a.Interface.Name == sel || ip != nil && a.IP.Equal(ip) {
+				chosen = append(chosen, a)
+			}
+		}
+		if len(chosen) == n {
+			return nil, fmt.Errorf("no usable address on interface %q", sel)
+		}
+	}
+	return chosen, nil
+}
+
+// Interfaces returns the distinct interfaces of addrs.
+func Interfaces(addrs []Address) []net.Interface {
+	var ifaces []net.Interface
+	for _, a := range addrs {
+		if !slices.ContainsFunc(ifaces, func(i net.Interface) bool { return i.Index == a.Interface.Index }) {
+			ifaces = append(ifaces, a.Interface)
+		}
+	}
+	return ifaces
+}
+
+func isVirtual(iface net.Interface) bool {
+	if iface.Flags&net.FlagPointToPoint != 0 {
+		return true
+	}
+	name := strings.ToLower(iface.Name)
+	for _, p := range virtualPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	// On Linux, interfaces backed by hardware have a device link in sysfs.
+	if _, err := os.Stat("/sys/class/net"); err == nil {
+		if _, err := os.Stat("/sys/class/net/" + iface.Name + "/device"); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultRouteIPs returns the source addresses the system would use to
+// reach the internet. Connecting a UDP socket sends nothing; it only asks
+// the kernel to pick a route.
+func defaultRouteIPs() []net.IP {
+	var ips []net.IP
+	for _, target := range []string{"192.0.2.1:9", "[2001:db8::1]:9"} {
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			continue
+		}
+		ips = append(ips, conn.LocalAddr().(*net.UDPAddr).IP)
+		conn.Close()
+	}
+	return ips
+}
+
+// compareBool orders true before false.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	}
+	return 1
+}
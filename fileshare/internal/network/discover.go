@@ -0,0 +1,86 @@
+package network
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Server is a fileshare server found on the network.
+type Server struct {
+	Instance string            `json:"instance"`
+	Host     string            `json:"host"`
+	Port     int               `json:"port"`
+	Addrs    []net.IP          `json:"addrs"`
+	TXT      map[string]string `json:"txt"`
+}
+
+// URL addresses the server by IP, preferring IPv4, since .local names do
+// not resolve everywhere.
+func (s Server) URL() string {
+	scheme := "http"
+	if s.TXT["tls"] == "1" {
+		scheme = "https"
+	}
+	host := strings.TrimSuffix(s.Host, ".")
+	if len(s.Addrs) > 0 {
+		host = s.Addrs[0].String()
+	}
+	return scheme + "://" + net.JoinHostPort(host, fmt.Sprint(s.Port)) + cmp.Or(s.TXT["path"], "/")
+}
+
+// Fingerprint returns the certificate fingerprint the server announced,
+// or "" if it did not.
+func (s Server) Fingerprint() string {
+	return strings.TrimPrefix(s.TXT["fp"], "sha256:")
+}
+
+// Discover lists the fileshare servers that answer within wait, sorted by
+// name.
+func Discover(ctx context.Context, wait time.Duration) ([]Server, error) {
+	entries, err := Browse(ctx, nil, wait, ServiceType)
+	if err != nil {
+		return nil, err
+	}
+	var servers []Server
+	for _, e := range entries {
+		s := Server{
+			Instance: InstanceName(e),
+			Host:     e.HostName,
+			Port:     e.Port,
+			TXT:      map[string]string{},
+		}
+		s.Addrs = append(append(s.Addrs, e.AddrIPv4...), e.AddrIPv6...)
+		for _, kv := range e.Text {
+			k, v, _ := strings.Cut(kv, "=")
+			s.TXT[k] = v
+		}
+		// The same server answers once per interface it is announced on.
+		if !slices.ContainsFunc(servers, func(o Server) bool {
+			return o.Instance == s.Instance && o.Host == s.Host && o.Port == s.Port
+		}) {
+			servers = append(servers, s)
+		}
+	}
+	slices.SortFunc(servers, func(a, b Server) int { return strings.Compare(a.Instance, b.Instance) })
+	return servers, nil
+}
+
+// FindServer discovers the server whose instance or host name is name.
+func FindServer(ctx context.Context, name string, wait time.Duration) (Server, error) {
+	servers, err := Discover(ctx, wait)
+	if err != nil {
+		return Server{}, err
+	}
+	for _, s := range servers {
+		host := strings.TrimSuffix(strings.TrimSuffix(s.Host, "."), ".local")
+		if strings.EqualFold(s.Instance, name) || strings.EqualFold(host, name) {
+			return s, nil
+		}
+	}
+	return Server{}, fmt.Errorf("no fileshare server named %q found on the network", name)
+}
@@ -1,66 +1,214 @@
-//Package network
+// Package network
 package network
 
 import (
-	"log"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/grandcat/zeroconf"
 )
 
-func GetLocalIP() (string, *net.Interface) {
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return "", nil
+const (
+	// ServiceType is browsed by "fileshare discover". Servers are announced
+	// as _http._tcp too, for generic browsers.
+	ServiceType = "_fileshare._tcp"
+	httpService = "_http._tcp"
+
+	// conflictWait is how long to listen for other servers before picking
+	// a name.
+	conflictWait = 1500 * time.Millisecond
+)
+
+// Announcement is what StartMDNS publishes about the server.
+type Announcement struct {
+	// Name is the host name, reachable as Name.local. It gets a -2, -3...
+	// suffix if another server on the network already uses it.
+	Name string
+	// Instance is the human-readable service name; it defaults to Name.
+	Instance string
+	Port     int
+	Addrs    []Address
+
+	Version string
+	TLS     bool
+	// CertFingerprint is the SHA-256 of the certificate, letting clients
+	// pin a self-signed certificate.
+	CertFingerprint string
+	Admin           bool
+	Upload          bool
+	// Push says the server takes "fileshare push" offers.
+	Push bool
+}
+
+// TXT returns the announcement's DNS-SD TXT records.
+func (a Announcement) TXT() []string {
+	txt := []string{
+		"txtvers=1",
+		"path=/",
+		"version=" + a.Version,
+		"tls=" + boolTXT(a.TLS),
+		"auth=none",
+		"admin=" + boolTXT(a.Admin),
+		"upload=" + boolTXT(a.Upload),
+		"push=" + boolTXT(a.Push),
 	}
-	for _, iface := range interfaces {
-		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
-			continue
-		}
-		addrs, err := iface.Addrs()
+	if a.CertFingerprint != "" {
+		txt = append(txt, "fp=sha256:"+a.CertFingerprint)
+	}
+	return txt
+}
+
+func boolTXT(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// MDNS is a running announcement. A nil *MDNS does nothing.
+type MDNS struct {
+	// Name and Instance are what was announced, after conflict resolution.
+	Name     string
+	Instance string
+	servers  []*zeroconf.Server
+}
+
+// Shutdown withdraws the announcement.
+func (m *MDNS) Shutdown() {
+	if m == nil {
+		return
+	}
+	for _, s := range m.servers {
+		s.Shutdown()
+	}
+}
+
+// StartMDNS announces a.Name.local on every interface of a.Addrs, resolving
+// to all of their addresses, after making sure no other server on the
+// network is using the name.
+func StartMDNS(a Announcement) (*MDNS, error) {
+	if len(a.Addrs) == 0 {
+		slog.Warn("No suitable network for mDNS")
+		return nil, nil
+	}
+
+	interfaces := Interfaces(a.Addrs)
+	hosts, instances := namesInUse(interfaces)
+	m := &MDNS{Name: unique(a.Name, hosts)}
+	m.Instance = unique(cmp.Or(a.Instance, m.Name), instances)
+	if m.Name != a.Name {
+		slog.Warn("mDNS name already in use on the network", "name", a.Name, "using", m.Name)
+	}
+
+	ips := make([]string, len(a.Addrs))
+	for i, addr := range a.Addrs {
+		ips[i] = addr.IP.String()
+	}
+	for _, service := range []string{ServiceType, httpService} {
+		server, err := zeroconf.RegisterProxy(m.Instance, service, "local.", a.Port, m.Name, ips, a.TXT(), interfaces)
 		if err != nil {
-			continue
+			m.Shutdown()
+			slog.Error("Could not start mDNS", "err", err)
+			return nil, err
 		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			if ip != nil && ip.To4() != nil {
-				return ip.String(), &iface
-			}
+		m.servers = append(m.servers, server)
+	}
+
+	names := make([]string, len(interfaces))
+	for i, iface := range interfaces {
+		names[i] = iface.Name
+	}
+	slog.Info("mDNS active", "url", fmt.Sprintf("http://%s.local:%d", m.Name, a.Port), "instance", m.Instance,
+		"interfaces", names, "addresses", ips)
+	return m, nil
+}
+
+// namesInUse browses for other servers and returns their host and
+// instance names, lower-cased.
+func namesInUse(interfaces []net.Interface) (hosts, instances map[string]bool) {
+	hosts, instances = map[string]bool{}, map[string]bool{}
+	entries, err := Browse(context.Background(), interfaces, conflictWait, ServiceType, httpService)
+	if err != nil {
+		slog.Debug("Could not check for mDNS name conflicts", "err", err)
+	}
+	for _, e := range entries {
+		hosts[strings.ToLower(strings.TrimSuffix(e.HostName, ".local."))] = true
+		instances[strings.ToLower(InstanceName(e))] = true
+	}
+	return hosts, instances
+}
+
+// InstanceName returns e's instance name without the DNS escaping of
+// spaces and dots.
+func InstanceName(e *zeroconf.ServiceEntry) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range e.Instance {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
 		}
+		escaped = false
+		b.WriteRune(r)
 	}
-	return "localhost", nil
+	return b.String()
 }
 
-func StartMDNS(port int, ip string, iface *net.Interface) (*zeroconf.Server, error){
+// unique returns name, or name-2, name-3... whichever is not taken first.
+func unique(name string, taken map[string]bool) string {
+	candidate := name
+	for n := 2; taken[strings.ToLower(candidate)]; n++ {
+		candidate = name + "-" + strconv.Itoa(n)
+	}
+	return candidate
+}
 
-	if iface == nil {
-		log.Println("No suitable network for mDNS")
-		return nil, nil
+// Browse collects the services of the given types announced on interfaces
+// (all when nil) within wait.
+func Browse(ctx context.Context, interfaces []net.Interface, wait time.Duration, services ...string) ([]*zeroconf.ServiceEntry, error) {
+	var opts []zeroconf.ClientOption
+	if len(interfaces) > 0 {
+		opts = append(opts, zeroconf.SelectIfaces(interfaces))
 	}
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
 
-	hostName := "fileshare"
-	interfaces := []net.Interface{*iface}
-	server, err := zeroconf.RegisterProxy(
-		"FileShare",
-		"_http._tcp",
-		"local.",
-		port,
-		hostName,
-		[]string{ip},
-		[]string{"txtv=0", "lo=1", "la=2"},
-		interfaces,
+	var (
+		mu  sync.Mutex
+		all []*zeroconf.ServiceEntry
+		wg  sync.WaitGroup
 	)
-	if err != nil {
-		log.Printf("Could not start mDNS: %v", err)
-		return nil, err
+	for _, service := range services {
+		resolver, err := zeroconf.NewResolver(opts...)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return nil, err
+		}
+		// Browse closes entries once ctx is done.
+		entries := make(chan *zeroconf.ServiceEntry)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range entries {
+				mu.Lock()
+				all = append(all, e)
+				mu.Unlock()
+			}
+		}()
+		if err := resolver.Browse(ctx, service, "local.", entries); err != nil {
+			cancel()
+			wg.Wait()
+			return nil, err
+		}
 	}
-	log.Printf("mDNS active: http://%s:%d (Bound to %s)", hostName, port, iface.Name)
-
-	return server, nil
+	wg.Wait()
+	return all, nil
 }
@@ -0,0 +1,139 @@
+// Package proxy
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Trusted is the set of reverse proxies whose X-Forwarded-For and
+// X-Forwarded-Proto headers are believed. A nil *Trusted trusts nobody.
+type Trusted struct {
+	prefixes []netip.Prefix
+}
+
+// ParseTrusted accepts IP addresses and CIDR ranges such as 10.0.0.0/8.
+func ParseTrusted(list []string) (*Trusted, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	t := &Trusted{}
+	for _, s := range list {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			t.prefixes = append(t.prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q (want an IP or CIDR)", s)
+		}
+		t.prefixes = append(t.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return t, nil
+}
+
+// Contains reports whether addr is a trusted proxy.
+func (t *Trusted) Contains(addr netip.Addr) bool {
+	if t == nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range t.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rewrites requests that arrive through a trusted proxy so the
+// rest of the server sees the real client: RemoteAddr becomes the client's
+// address from X-Forwarded-For and Scheme reports X-Forwarded-Proto.
+func (t *Trusted) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		remoteAddr := r.RemoteAddr
+		if peer, err := netip.ParseAddrPort(r.RemoteAddr); err == nil && t.Contains(peer.Addr()) {
+			if client, ok := t.forwardedFor(r.Header.Values("X-Forwarded-For")); ok {
+				remoteAddr = net.JoinHostPort(client.String(), "0")
+			}
+			switch proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto {
+			case "http", "https":
+				scheme = proto
+			}
+		}
+
+		r2 := *r
+		u := *r.URL
+		u.Scheme = scheme
+		r2.URL = &u
+		r2.RemoteAddr = remoteAddr
+		next.ServeHTTP(w, &r2)
+	})
+}
+
+// forwardedFor walks X-Forwarded-For from the nearest hop outwards and
+// returns the first address that is not a trusted proxy. Addresses further
+// out were supplied by the client and cannot be believed.
+func (t *Trusted) forwardedFor(values []string) (netip.Addr, bool) {
+	var hops []string
+	for _, v := range values {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	var last netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		last = addr.Unmap()
+		if !t.Contains(last) {
+			return last, true
+		}
+	}
+	return last, last.IsValid()
+}
+
+// Scheme returns "https" or "http" as the client sees it, taking a trusted
+// proxy's X-Forwarded-Proto into account.
+func Scheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// RedirectHandler sends every request to the same path over HTTPS on
+// httpsPort.
+func RedirectHandler(httpsPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			http.Error(w, "Missing Host header", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	}
+}
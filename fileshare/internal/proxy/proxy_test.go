@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParseTrusted(t *testing.T) {
+	tr, err := ParseTrusted([]string{"127.0.0.1", "10.0.0.0/8", "fd00::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"127.0.0.1":        true,
+		"::ffff:127.0.0.1": true,
+		"127.0.0.2":        false,
+		"10.200.3.4":       true,
+		"11.0.0.1":         false,
+		"fd00::1":          true,
+		"fd00::2":          false,
+	} {
+		if got := tr.Contains(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	if tr, err := ParseTrusted(nil); tr != nil || err != nil {
+		t.Errorf("ParseTrusted(nil) = %v, %v", tr, err)
+	}
+	if _, err := ParseTrusted([]string{"proxy.local"}); err == nil {
+		t.Error("host name accepted")
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	tr, err := ParseTrusted([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{name: "single hop", values: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "spoofed prefix ignored", values: []string{"1.2.3.4, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "trusted hops skipped", values: []string{"203.0.113.7, 10.0.0.5, 10.0.0.6"}, want: "203.0.113.7"},
+		{name: "repeated headers joined", values: []string{"1.2.3.4", "203.0.113.7, 10.0.0.5"}, want: "203.0.113.7"},
+		{name: "spaces and mapped v4", values: []string{" ::ffff:203.0.113.7 ,10.0.0.5"}, want: "203.0.113.7"},
+		{name: "ipv6", values: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "only proxies", values: []string{"10.0.0.5, 10.0.0.6"}, want: "10.0.0.5"},
+		{name: "garbage stops the walk", values: []string{"203.0.113.7, unknown, 10.0.0.5"}, want: "10.0.0.5"},
+		{name: "garbage nearest", values: []string{"203.0.113.7, unknown"}},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, ok := tr.forwardedFor(tt.values)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("forwardedFor = %s, want none", addr)
+				}
+				return
+			}
+			if !ok || addr.String() != tt.want {
+				t.Errorf("forwardedFor = %s, %v, want %s", addr, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tr, err := ParseTrusted([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		xff, proto string
+		wantAddr   string
+		wantScheme string
+	}{
+		{name: "direct", remoteAddr: "192.0.2.1:5000", wantAddr: "192.0.2.1:5000", wantScheme: "http"},
+		{name: "direct tls", remoteAddr: "192.0.2.1:5000", tls: true, wantAddr: "192.0.2.1:5000", wantScheme: "https"},
+		{name: "untrusted headers ignored", remoteAddr: "192.0.2.1:5000", xff: "203.0.113.7", proto: "https",
+			wantAddr: "192.0.2.1:5000", wantScheme: "http"},
+		{name: "through proxy", remoteAddr: "127.0.0.1:5000", xff: "203.0.113.7", proto: "HTTPS",
+			wantAddr: "203.0.113.7:0", wantScheme: "https"},
+		{name: "proxy without headers", remoteAddr: "127.0.0.1:5000", wantAddr: "127.0.0.1:5000", wantScheme: "http"},
+		{name: "bad proto ignored", remoteAddr: "127.0.0.1:5000", tls: true, proto: "ftp", wantAddr: "127.0.0.1:5000", wantScheme: "https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAddr, gotScheme string
+			h := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAddr, gotScheme = r.RemoteAddr, Scheme(r)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if gotAddr != tt.wantAddr || gotScheme != tt.wantScheme {
+				t.Errorf("got %s %s, want %s %s", gotAddr, gotScheme, tt.wantAddr, tt.wantScheme)
+			}
+		})
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port, host, target string
+		method             string
+		want               string
+		code               int
+	}{
+		{"8443", "example.lan:8080", "/a?b=c", http.MethodGet, "https://example.lan:8443/a?b=c", http.StatusMovedPermanently},
+		{"443", "example.lan", "/", http.MethodGet, "https://example.lan/", http.StatusMovedPermanently},
+		{"443", "[fd00::1]:80", "/", http.MethodPost, "https://[fd00::1]/", http.StatusPermanentRedirect},
+		{"8443", "[fd00::1]:80", "/", http.MethodGet, "https://[fd00::1]:8443/", http.StatusMovedPermanently},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		RedirectHandler(tt.port)(rec, req)
+		if rec.Code != tt.code || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s %s%s: %d %s, want %d %s", tt.method, tt.host, tt.target, rec.Code, rec.Header().Get("Location"), tt.code, tt.want)
+		}
+	}
+}
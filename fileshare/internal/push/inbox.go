@@ -0,0 +1,205 @@
+// Package push
+package push
+
+import (
+	"context"
+	"errors"
+	"fileshare/internal/logging"
+	"slices"
+	"sync"
+	"time"
+)
+
+// State is where an offer stands.
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateAccepted State = "accepted"
+	StateDeclined State = "declined"
+	StateExpired  State = "expired"
+)
+
+// OfferHeader carries the accepted offer's ID on every chunk a sender
+// uploads, so /upload can check the file was agreed to.
+const OfferHeader = "X-Push-Offer"
+
+var (
+	ErrUnknownOffer = errors.New("push: no such offer")
+	ErrAnswered     = errors.New("push: offer was already answered")
+	ErrNotAccepted  = errors.New("push: offer was not accepted")
+	ErrNotOffered   = errors.New("push: file is not part of the offer")
+)
+
+// File is one file a sender wants to push.
+type File struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Offer is a sender's request to push files to this server.
+type Offer struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	Client  string    `json:"client"`
+	Files   []File    `json:"files"`
+	Total   int64     `json:"total"`
+	Created time.Time `json:"created"`
+	State   State     `json:"state"`
+
+	answered chan struct{}
+	// used is when a chunk last arrived for an accepted offer.
+	used time.Time
+}
+
+// Inbox holds the offers waiting for the receiver to accept or decline them.
+type Inbox struct {
+	// TTL is how long an offer waits for an answer before it expires.
+	TTL time.Duration
+
+	mu       sync.Mutex
+	offers   map[string]*Offer
+	incoming chan Offer
+}
+
+func NewInbox(ttl time.Duration) *Inbox {
+	return &Inbox{
+		TTL:      ttl,
+		offers:   map[string]*Offer{},
+		incoming: make(chan Offer, 16),
+	}
+}
+
+// Add records a new pending offer and announces it on Incoming.
+func (in *Inbox) Add(from, client string, files []File) Offer {
+	o := &Offer{
+		ID:       logging.NewID(),
+		From:     from,
+		Client:   client,
+		Files:    files,
+		Created:  time.Now(),
+		State:    StatePending,
+		answered: make(chan struct{}),
+	}
+	for _, f := range files {
+		o.Total += f.Size
+	}
+
+	in.mu.Lock()
+	in.offers[o.ID] = o
+	in.sweep()
+	snapshot := *o
+	in.mu.Unlock()
+
+	time.AfterFunc(in.TTL, func() { in.answer(o.ID, StateExpired) })
+	select {
+	case in.incoming <- snapshot:
+	default:
+		// Nobody is prompting in the terminal; the browser can still answer.
+	}
+	return snapshot
+}
+
+// Incoming delivers each new offer so it can be put to the receiver.
+func (in *Inbox) Incoming() <-chan Offer {
+	return in.incoming
+}
+
+// Answer accepts or declines a pending offer.
+func (in *Inbox) Answer(id string, accept bool) error {
+	state := StateDeclined
+	if accept {
+		state = StateAccepted
+	}
+	return in.answer(id, state)
+}
+
+func (in *Inbox) answer(id string, state State) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	o := in.offers[id]
+	if o == nil {
+		return ErrUnknownOffer
+	}
+	if o.State != StatePending {
+		return ErrAnswered
+	}
+	o.State = state
+	close(o.answered)
+	return nil
+}
+
+// Wait blocks until the offer is answered or ctx is done and returns its
+// state at that point.
+func (in *Inbox) Wait(ctx context.Context, id string) (Offer, error) {
+	in.mu.Lock()
+	o := in.offers[id]
+	in.mu.Unlock()
+	if o == nil {
+		return Offer{}, ErrUnknownOffer
+	}
+	select {
+	case <-o.answered:
+	case <-ctx.Done():
+	}
+	return in.Get(id)
+}
+
+// Get returns a copy of an offer.
+func (in *Inbox) Get(id string) (Offer, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	o := in.offers[id]
+	if o == nil {
+		return Offer{}, ErrUnknownOffer
+	}
+	return *o, nil
+}
+
+// Check reports whether client may upload the file name of size under
+// offer id. Each successful check keeps an accepted offer from being swept
+// while its files are still arriving.
+func (in *Inbox) Check(id, client, name string, size int64) error {
+	if in == nil {
+		return ErrUnknownOffer
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	o := in.offers[id]
+	if o == nil || o.Client != client {
+		return ErrUnknownOffer
+	}
+	if o.State != StateAccepted {
+		return ErrNotAccepted
+	}
+	if !slices.Contains(o.Files, File{Name: name, Size: size}) {
+		return ErrNotOffered
+	}
+	o.used = time.Now()
+	return nil
+}
+
+// Pending returns the offers still waiting for an answer, oldest first.
+func (in *Inbox) Pending() []Offer {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var pending []Offer
+	for _, o := range in.offers {
+		if o.State == StatePending {
+			pending = append(pending, *o)
+		}
+	}
+	slices.SortFunc(pending, func(a, b Offer) int { return a.Created.Compare(b.Created) })
+	return pending
+}
+
+// sweep forgets answered offers the sender has had ample time to collect,
+// and accepted ones whose upload has gone quiet. Caller must hold mu.
+func (in *Inbox) sweep() {
+	cutoff := time.Now().Add(-2 * in.TTL)
+	for id, o := range in.offers {
+		if o.State != StatePending && o.Created.Before(cutoff) && o.used.Before(cutoff) {
+			delete(in.offers, id)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package push
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInboxCheck(t *testing.T) {
+	in := NewInbox(time.Minute)
+	o := in.Add("laptop", "192.0.2.1", []File{{Name: "a.bin", Size: 4}})
+
+	tests := []struct {
+		name, client, file string
+		size               int64
+		want               error
+	}{
+		{"pending", "192.0.2.1", "a.bin", 4, ErrNotAccepted},
+		{"accepted", "192.0.2.1", "a.bin", 4, nil},
+		{"other client", "192.0.2.2", "a.bin", 4, ErrUnknownOffer},
+		{"other file", "192.0.2.1", "b.bin", 4, ErrNotOffered},
+		{"other size", "192.0.2.1", "a.bin", 5, ErrNotOffered},
+	}
+	for _, tt := range tests {
+		if tt.name == "accepted" {
+			if err := in.Answer(o.ID, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := in.Check(o.ID, tt.client, tt.file, tt.size); err != tt.want {
+			t.Errorf("%s: Check = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if err := (*Inbox)(nil).Check(o.ID, "192.0.2.1", "a.bin", 4); err != ErrUnknownOffer {
+		t.Errorf("nil inbox: Check = %v", err)
+	}
+}
+
+func TestInboxKeepsOffersInUse(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	in := NewInbox(ttl)
+	files := []File{{Name: "a.bin", Size: 4}}
+	busy := in.Add("laptop", "192.0.2.1", files)
+	idle := in.Add("laptop", "192.0.2.1", files)
+	in.Answer(busy.ID, true)
+	in.Answer(idle.ID, true)
+
+	// Chunks keep arriving for busy long after it was answered.
+	for range 6 {
+		time.Sleep(ttl / 2)
+		if err := in.Check(busy.ID, "192.0.2.1", "a.bin", 4); err != nil {
+			t.Fatalf("offer in use was swept: %v", err)
+		}
+	}
+	in.Add("phone", "192.0.2.3", files)
+	if err := in.Check(busy.ID, "192.0.2.1", "a.bin", 4); err != nil {
+		t.Errorf("busy offer: %v", err)
+	}
+	if _, err := in.Get(idle.ID); err != ErrUnknownOffer {
+		t.Errorf("idle offer: Get = %v, want it swept", err)
+	}
+}
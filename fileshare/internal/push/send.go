@@ -0,0 +1,230 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxChunkAttempts = 5
+
+// ErrDeclined is returned by Sender.Wait when the receiver says no.
+var ErrDeclined = errors.New("the receiver declined")
+
+// LocalFile is a file to send: Path on this machine, Name on the receiver.
+type LocalFile struct {
+	Path string
+	File
+}
+
+// Answer is the receiver's reply to an offer.
+type Answer struct {
+	Offer
+	Dir            string `json:"dir"`
+	ChunkSize      int64  `json:"chunkSize"`
+	ParallelChunks int    `json:"parallelChunks"`
+}
+
+// Sender pushes files to one receiver over the chunked upload protocol.
+type Sender struct {
+	BaseURL string
+	Client  *http.Client
+	// Progress, if set, is called as chunks complete.
+	Progress func(f LocalFile, sent int64)
+}
+
+// PinnedClient returns a client that accepts the server's certificate only
+// if its SHA-256 matches fingerprint, which is how self-signed certificates
+// are trusted. With no fingerprint any certificate is accepted.
+func PinnedClient(fingerprint string) *http.Client {
+	want := strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if want == "" {
+				return nil
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("push: server sent no certificate")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if hex.EncodeToString(sum[:]) != want {
+				return errors.New("push: server certificate does not match the announced fingerprint")
+			}
+			return nil
+		},
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf, ForceAttemptHTTP2: true}}
+}
+
+// Offer asks the receiver to accept files.
+func (s *Sender) Offer(ctx context.Context, from string, files []LocalFile) (Answer, error) {
+	req := struct {
+		From  string `json:"from"`
+		Files []File `json:"files"`
+	}{From: from}
+	for _, f := range files {
+		req.Files = append(req.Files, f.File)
+	}
+	body, _ := json.Marshal(req)
+	var a Answer
+	err := s.do(ctx, http.MethodPost, "/push/offer", bytes.NewReader(body), &a)
+	return a, err
+}
+
+// Wait polls until the receiver answers. It returns ErrDeclined when the
+// offer is declined or expires.
+func (s *Sender) Wait(ctx context.Context, id string) (Answer, error) {
+	for {
+		var a Answer
+		if err := s.do(ctx, http.MethodGet, "/push/offer?wait=1&id="+url.QueryEscape(id), nil, &a); err != nil {
+			return a, err
+		}
+		switch a.State {
+		case StateAccepted:
+			return a, nil
+		case StateDeclined:
+			return a, ErrDeclined
+		case StateExpired:
+			return a, fmt.Errorf("%w: no answer in time", ErrDeclined)
+		}
+	}
+}
+
+func (s *Sender) do(ctx context.Context, method, path string, body io.Reader, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.BaseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Send uploads one accepted file in chunks, a.ParallelChunks at a time,
+// the same way the browser does.
+func (s *Sender) Send(ctx context.Context, a Answer, f LocalFile) error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	chunkSize := max(a.ChunkSize, 64<<10)
+	parallel := max(a.ParallelChunks, 1)
+	uploadID := fmt.Sprintf("push-%s-%s", a.ID, f.Name)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	offsets := make(chan int64)
+	go func() {
+		defer close(offsets)
+		// An empty file still needs one chunk to create it.
+		for off := int64(0); off == 0 || off < f.Size; off += chunkSize {
+			select {
+			case offsets <- off:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		sent     atomic.Int64
+		errOnce  sync.Once
+		firstErr error
+	)
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for off := range offsets {
+				n := min(chunkSize, f.Size-off)
+				if err := s.chunk(ctx, a, f, uploadID, io.NewSectionReader(file, off, n), off, n, false); err != nil {
+					errOnce.Do(func() { firstErr = err; cancel() })
+					return
+				}
+				if s.Progress != nil {
+					s.Progress(f, sent.Add(n))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return s.chunk(ctx, a, f, uploadID, nil, 0, 0, true)
+}
+
+// chunk posts one chunk, retrying when the server asks the client to back
+// off.
+func (s *Sender) chunk(ctx context.Context, a Answer, f LocalFile, uploadID string, body *io.SectionReader, off, n int64, final bool) error {
+	for attempt := 1; ; attempt++ {
+		var r io.Reader = http.NoBody
+		if body != nil {
+			body.Seek(0, io.SeekStart)
+			r = body
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.BaseURL, "/")+"/upload?dir="+url.QueryEscape(a.Dir), r)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = n
+		req.Header.Set("X-File-Name", f.Name)
+		req.Header.Set("X-File-Size", strconv.FormatInt(f.Size, 10))
+		req.Header.Set("X-Chunk-Offset", strconv.FormatInt(off, 10))
+		req.Header.Set("X-Upload-ID", uploadID)
+		req.Header.Set(OfferHeader, a.ID)
+		if final {
+			req.Header.Set("X-Final-Chunk", "true")
+		}
+
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= maxChunkAttempts {
+				return err
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return nil
+		case (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) && attempt < maxChunkAttempts:
+			wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			select {
+			case <-time.After(time.Duration(max(wait, 1)) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		default:
+			return fmt.Errorf("upload %s at offset %d: %s", f.Name, off, resp.Status)
+		}
+	}
+}
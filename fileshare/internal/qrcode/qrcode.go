@@ -0,0 +1,118 @@
+// Package qrcode draws QR codes in the terminal and as PNG or SVG images.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// quietZone is the light border scanners expect around a code, in modules.
+const quietZone = 4
+
+// ErrTooWide means the code would not fit in the terminal.
+var ErrTooWide = errors.New("terminal too narrow for the QR code")
+
+// Options change how Terminal draws a code.
+type Options struct {
+	// Invert draws dark modules instead of light ones, for terminals with
+	// a light background.
+	Invert bool
+	// Compact uses a one-module border instead of the standard four.
+	Compact bool
+}
+
+// Terminal writes text as a QR code made of half-block characters, two
+// modules per line. With width > 0 it returns ErrTooWide rather than
+// printing a code wider than width columns.
+func Terminal(w io.Writer, text string, width int, opts Options) error {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return err
+	}
+	border := quietZone
+	if opts.Compact {
+		border = 1
+	}
+	if side := code.Size + 2*border; width > 0 && side > width {
+		return fmt.Errorf("%w (%d columns needed, %d available)", ErrTooWide, side, width)
+	}
+
+	// The terminal's foreground colour draws light modules, unless inverted.
+	drawn := func(x, y int) bool { return code.Black(x, y) == opts.Invert }
+	var b strings.Builder
+	for y := -border; y < code.Size+border; y += 2 {
+		for x := -border; x < code.Size+border; x++ {
+			top, bottom := drawn(x, y), drawn(x, y+1)
+			if y+1 >= code.Size+border {
+				bottom = false
+			}
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// PNG encodes text as a PNG image with eight pixels per module.
+func PNG(text string) ([]byte, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return nil, err
+	}
+	return code.PNG(), nil
+}
+
+// SVG encodes text as a scalable SVG image.
+func SVG(text string) ([]byte, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return nil, err
+	}
+	side := code.Size + 2*quietZone
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, side, side)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, side, side)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String()), nil
+}
+
+// WriteFile saves text as a QR code image, as SVG when path ends in .svg
+// and PNG otherwise. The code may carry credentials, so only the owner may
+// read the file, even one that already existed.
+func WriteFile(path, text string) error {
+	encode := PNG
+	if strings.EqualFold(filepath.Ext(path), ".svg") {
+		encode = SVG
+	}
+	data, err := encode(text)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
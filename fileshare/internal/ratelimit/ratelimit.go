@@ -0,0 +1,255 @@
+// Package ratelimit
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// chunkSize is the most bytes moved per token request, so a slow limit
+	// still delivers data smoothly instead of in one large burst.
+	chunkSize = 32 << 10
+
+	clientIdle = 10 * time.Minute
+)
+
+// Limits are caps in bytes per second. Zero means unlimited.
+type Limits struct {
+	Up         int64 `json:"up"`         // uploads from all clients combined
+	Down       int64 `json:"down"`       // downloads to all clients combined
+	ClientUp   int64 `json:"clientUp"`   // uploads from each client IP
+	ClientDown int64 `json:"clientDown"` // downloads to each client IP
+}
+
+type client struct {
+	up, down *rate.Limiter
+	lastUsed time.Time
+}
+
+// Limiter shapes transfer bandwidth with token buckets, one per direction
+// globally and per client. Limits can be changed while transfers run. A nil
+// Limiter does not limit anything.
+type Limiter struct {
+	mu        sync.Mutex
+	limits    Limits
+	enabled   bool
+	up, down  *rate.Limiter
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// New creates a limiter enforcing l.
+func New(l Limits) *Limiter {
+	lim := &Limiter{
+		enabled: true,
+		up:      rate.NewLimiter(rate.Inf, chunkSize),
+		down:    rate.NewLimiter(rate.Inf, chunkSize),
+		clients: map[string]*client{},
+	}
+	lim.Set(l)
+	return lim
+}
+
+// Limits returns the configured limits.
+func (l *Limiter) Limits() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limits
+}
+
+// Set replaces the limits, including for transfers already in progress.
+func (l *Limiter) Set(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	l.applyAll()
+}
+
+// Enabled reports whether limits are being enforced.
+func (l *Limiter) Enabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled
+}
+
+// SetEnabled suspends or restores enforcement without forgetting the limits.
+func (l *Limiter) SetEnabled(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enabled = enabled
+	l.applyAll()
+}
+
+// applyAll pushes the current limits into every bucket. Caller must hold mu.
+func (l *Limiter) applyAll() {
+	l.apply(l.up, l.limits.Up)
+	l.apply(l.down, l.limits.Down)
+	for _, c := range l.clients {
+		l.apply(c.up, l.limits.ClientUp)
+		l.apply(c.down, l.limits.ClientDown)
+	}
+}
+
+func (l *Limiter) apply(b *rate.Limiter, bps int64) {
+	if !l.enabled || bps <= 0 {
+		b.SetLimit(rate.Inf)
+		return
+	}
+	b.SetLimit(rate.Limit(bps))
+	b.SetBurst(int(max(bps, chunkSize)))
+}
+
+// buckets returns the global and per-client buckets for one direction.
+func (l *Limiter) buckets(r *http.Request, upload bool) []*rate.Limiter {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > clientIdle {
+		for addr, c := range l.clients {
+			if now.Sub(c.lastUsed) > clientIdle {
+				delete(l.clients, addr)
+			}
+		}
+		l.lastSweep = now
+	}
+	c := l.clients[host]
+	if c == nil {
+		c = &client{up: rate.NewLimiter(rate.Inf, chunkSize), down: rate.NewLimiter(rate.Inf, chunkSize)}
+		l.apply(c.up, l.limits.ClientUp)
+		l.apply(c.down, l.limits.ClientDown)
+		l.clients[host] = c
+	}
+	c.lastUsed = now
+	if upload {
+		return []*rate.Limiter{l.up, c.up}
+	}
+	return []*rate.Limiter{l.down, c.down}
+}
+
+func wait(ctx context.Context, n int, buckets []*rate.Limiter) error {
+	for _, b := range buckets {
+		if err := b.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writer limits the response body sent to the client of r.
+func (l *Limiter) Writer(r *http.Request, w http.ResponseWriter) http.ResponseWriter {
+	if l == nil {
+		return w
+	}
+	return &limitedWriter{ResponseWriter: w, ctx: r.Context(), buckets: l.buckets(r, false)}
+}
+
+// Reader limits how fast body, usually r.Body, is read from the client of r.
+func (l *Limiter) Reader(r *http.Request, body io.Reader) io.Reader {
+	if l == nil {
+		return body
+	}
+	return &limitedReader{Reader: body, ctx: r.Context(), buckets: l.buckets(r, true)}
+}
+
+type limitedWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*rate.Limiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), chunkSize)
+		if err := wait(w.ctx, n, w.buckets); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *limitedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type limitedReader struct {
+	io.Reader
+	ctx     context.Context
+	buckets []*rate.Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		if werr := wait(r.ctx, n, r.buckets); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// ParseRate reads a rate such as "500K", "10M" or "1.5G" (bytes per second,
+// powers of 1024). An empty string or "0" means unlimited.
+func ParseRate(rateStr string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(rateStr)), "/S")
+	s = strings.TrimSuffix(s, "B")
+	if s == "" {
+		return 0, nil
+	}
+	mult := 1.0
+	switch s[len(s)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate %q (want e.g. 500K, 10M or 0 for unlimited)", rateStr)
+	}
+	return int64(v * mult), nil
+}
+
+// FormatRate is the inverse of ParseRate, returning "" for unlimited.
+func FormatRate(bps int64) string {
+	switch {
+	case bps <= 0:
+		return ""
+	case bps%(1<<30) == 0:
+		return fmt.Sprintf("%dG", bps>>30)
+	case bps%(1<<20) == 0:
+		return fmt.Sprintf("%dM", bps>>20)
+	case bps%(1<<10) == 0:
+		return fmt.Sprintf("%dK", bps>>10)
+	}
+	return strconv.FormatInt(bps, 10)
+}
@@ -0,0 +1,137 @@
+// Package share
+package share
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CookieName holds the token of the link a browser arrived with.
+const CookieName = "fileshare_link"
+
+var ErrUnknownLink = errors.New("share: no such link")
+
+// Link grants access to Path and everything below it until it expires or is
+// revoked.
+type Link struct {
+	Token   string    `json:"token"`
+	Path    string    `json:"path"`
+	Created time.Time `json:"created"`
+	// Expires is zero for a link that lasts until it is revoked.
+	Expires time.Time `json:"expires,omitzero"`
+	Visits  int64     `json:"visits"`
+}
+
+// Allows reports whether urlPath is Path or inside it.
+func (l Link) Allows(urlPath string) bool {
+	p := path.Clean("/" + urlPath)
+	return l.Path == "/" || p == l.Path || strings.HasPrefix(p, l.Path+"/")
+}
+
+func (l Link) expired(now time.Time) bool {
+	return !l.Expires.IsZero() && now.After(l.Expires)
+}
+
+// Links holds the share links handed out since the server started; they do
+// not survive a restart. Methods are safe to call on a nil Links, which knows
+// no links.
+type Links struct {
+	mu    sync.Mutex
+	links map[string]*Link
+}
+
+func New() *Links {
+	return &Links{links: map[string]*Link{}}
+}
+
+// Create adds a link to urlPath that expires after ttl, or never if ttl is 0.
+func (s *Links) Create(urlPath string, ttl time.Duration) Link {
+	b := make([]byte, 16)
+	rand.Read(b)
+	l := &Link{Token: hex.EncodeToString(b), Path: path.Clean("/" + urlPath), Created: time.Now()}
+	if ttl > 0 {
+		l.Expires = l.Created.Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	s.links[l.Token] = l
+	return *l
+}
+
+// Lookup returns the live link for token.
+func (s *Links) Lookup(token string) (Link, bool) {
+	if s == nil {
+		return Link{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.links[token]
+	if l == nil || l.expired(time.Now()) {
+		return Link{}, false
+	}
+	return *l, true
+}
+
+// Visit is Lookup for someone opening the link, and counts the visit.
+func (s *Links) Visit(token string) (Link, bool) {
+	if s == nil {
+		return Link{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.links[token]
+	if l == nil || l.expired(time.Now()) {
+		return Link{}, false
+	}
+	l.Visits++
+	return *l, true
+}
+
+// Revoke ends a link at once and returns it.
+func (s *Links) Revoke(token string) (Link, error) {
+	if s == nil {
+		return Link{}, ErrUnknownLink
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.links[token]
+	if l == nil {
+		return Link{}, ErrUnknownLink
+	}
+	delete(s.links, token)
+	return *l, nil
+}
+
+// List returns the live links, oldest first.
+func (s *Links) List() []Link {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	var links []Link
+	for _, l := range s.links {
+		links = append(links, *l)
+	}
+	slices.SortFunc(links, func(a, b Link) int { return a.Created.Compare(b.Created) })
+	return links
+}
+
+// sweep forgets expired links. Caller must hold mu.
+func (s *Links) sweep() {
+	now := time.Now()
+	for token, l := range s.links {
+		if l.expired(now) {
+			delete(s.links, token)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package share
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLinkAllows(t *testing.T) {
+	tests := []struct {
+		link, path string
+		want       bool
+	}{
+		{"/", "/anything/at/all", true},
+		{"/docs", "/docs", true},
+		{"/docs", "/docs/sub/a.txt", true},
+		{"/docs", "/docs/../secret.txt", false},
+		{"/docs", "/docsx/a.txt", false},
+		{"/docs", "/", false},
+		{"/docs", "", false},
+		{"/docs/a.txt", "/docs/a.txt", true},
+		{"/docs/a.txt", "/docs/b.txt", false},
+	}
+	for _, tt := range tests {
+		if got := (Link{Path: tt.link}).Allows(tt.path); got != tt.want {
+			t.Errorf("link to %s allows %q = %v, want %v", tt.link, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	s := New()
+	forever := s.Create("docs/", 0)
+	if forever.Path != "/docs" || !forever.Expires.IsZero() {
+		t.Errorf("Create = %+v, want /docs without expiry", forever)
+	}
+	expired := s.Create("/", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if _, ok := s.Lookup(expired.Token); ok {
+		t.Error("expired link still works")
+	}
+	for range 2 {
+		s.Visit(forever.Token)
+	}
+	if l, ok := s.Lookup(forever.Token); !ok || l.Visits != 2 {
+		t.Errorf("Lookup = %+v, %v, want 2 visits", l, ok)
+	}
+	if links := s.List(); len(links) != 1 || links[0].Token != forever.Token {
+		t.Errorf("List = %+v, want only the live link", links)
+	}
+
+	if _, err := s.Revoke(forever.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Lookup(forever.Token); ok {
+		t.Error("revoked link still works")
+	}
+	if _, err := s.Revoke(forever.Token); err != ErrUnknownLink {
+		t.Errorf("second Revoke = %v, want ErrUnknownLink", err)
+	}
+}
+
+func TestNilLinks(t *testing.T) {
+	var s *Links
+	if _, ok := s.Lookup("x"); ok {
+		t.Error("nil Links knows a link")
+	}
+	if s.List() != nil {
+		t.Error("nil Links lists links")
+	}
+}
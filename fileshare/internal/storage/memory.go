@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+var errNotEmpty = errors.New("directory not empty")
+
+// Memory is an FS held entirely in memory, for tests. Readers see a
+// snapshot taken when they open a file; writes become visible on Sync or
+// Close.
+type Memory struct {
+	mu    sync.RWMutex
+	files fstest.MapFS
+}
+
+// NewMemory returns an FS holding a copy of seed, which may be nil.
+func NewMemory(seed fstest.MapFS) *Memory {
+	m := &Memory{files: fstest.MapFS{}}
+	for name, f := range seed {
+		clone := *f
+		clone.Data = append([]byte(nil), f.Data...)
+		m.files[name] = &clone
+	}
+	return m
+}
+
+// snapshot copies the name table. Stored MapFiles are never modified, only
+// replaced, so readers can keep using them after the lock is released.
+func (m *Memory) snapshot() fstest.MapFS {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.files)
+}
+
+func (m *Memory) Open(name string) (fs.File, error) {
+	return m.snapshot().Open(name)
+}
+
+func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	return m.snapshot().Stat(name)
+}
+
+func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
+	return m.snapshot().ReadDir(name)
+}
+
+// isDir reports whether name is a directory, explicit or implied by the
+// files under it. The caller holds mu.
+func (m *Memory) isDir(name string) bool {
+	info, err := m.files.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+// hasChildren reports whether any entry lives under dir. The caller holds mu.
+func (m *Memory) hasChildren(dir string) bool {
+	prefix := dir + "/"
+	for name := range m.files {
+		if dir == "." || strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) OpenWrite(name string) (File, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.isDir(path.Dir(name)) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if m.isDir(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := m.files[name]; !ok {
+		m.files[name] = &fstest.MapFile{Mode: 0644, ModTime: time.Now()}
+	}
+	return &memFile{m: m, name: name}, nil
+}
+
+func (m *Memory) MkdirAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == "." {
+		return nil
+	}
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/")
+		if f, ok := m.files[dir]; ok {
+			if !f.Mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+			}
+			continue
+		}
+		m.files[dir] = &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *Memory) Rename(oldname, newname string) error {
+	if !fs.ValidPath(oldname) || !fs.ValidPath(newname) || oldname == "." || newname == "." {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, err := m.files.Stat(oldname)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if !m.isDir(path.Dir(newname)) {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrNotExist}
+	}
+	if m.isDir(newname) || info.IsDir() && strings.HasPrefix(newname, oldname+"/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+	}
+	if !info.IsDir() {
+		m.files[newname] = m.files[oldname]
+		delete(m.files, oldname)
+		return nil
+	}
+	delete(m.files, newname)
+	for name, f := range maps.Clone(m.files) {
+		if name == oldname || strings.HasPrefix(name, oldname+"/") {
+			m.files[newname+strings.TrimPrefix(name, oldname)] = f
+			delete(m.files, name)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hasChildren(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+// memFile collects writes and applies them to the stored file on Sync, so
+// several writers can fill different ranges of one file at once.
+type memFile struct {
+	m       *Memory
+	name    string
+	pos     int64
+	pending []span
+	closed  bool
+}
+
+type span struct {
+	off  int64
+	data []byte
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if n := len(f.pending); n > 0 && f.pending[n-1].off+int64(len(f.pending[n-1].data)) == f.pos {
+		f.pending[n-1].data = append(f.pending[n-1].data, p...)
+	} else {
+		f.pending = append(f.pending, span{off: f.pos, data: append([]byte(nil), p...)})
+	}
+	f.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		info, err := f.m.Stat(f.name)
+		if err != nil {
+			return 0, err
+		}
+		offset += info.Size()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *memFile) Sync() error {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	old, ok := f.m.files[f.name]
+	if !ok {
+		// Removed or renamed away while open; like an unlinked file on
+		// disk, the writes go nowhere.
+		f.pending = nil
+		return nil
+	}
+	size := int64(len(old.Data))
+	for _, s := range f.pending {
+		size = max(size, s.off+int64(len(s.data)))
+	}
+	data := make([]byte, size)
+	copy(data, old.Data)
+	for _, s := range f.pending {
+		copy(data[s.off:], s.data)
+	}
+	f.m.files[f.name] = &fstest.MapFile{Data: data, Mode: old.Mode, ModTime: time.Now()}
+	f.pending = nil
+	return nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	err := f.Sync()
+	f.closed = true
+	return err
+}
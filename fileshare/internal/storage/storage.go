@@ -0,0 +1,127 @@
+// Package storage abstracts where shared files live. Names follow io/fs
+// conventions: slash-separated, unrooted, with "." for the top directory.
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// FS is a file tree that can be read through io/fs and also written to.
+type FS interface {
+	fs.StatFS
+	fs.ReadDirFS
+
+	// OpenWrite opens name for writing, creating it if it does not exist.
+	// Existing content is kept so chunks can be written at any offset.
+	OpenWrite(name string) (File, error)
+	// MkdirAll creates name and any missing parents.
+	MkdirAll(name string) error
+	// Rename moves oldname to newname, replacing a file already there.
+	Rename(oldname, newname string) error
+	// Remove deletes a file or an empty directory.
+	Remove(name string) error
+}
+
+// File is a file open for writing.
+type File interface {
+	io.WriteSeeker
+	io.Closer
+	// Sync commits what has been written so readers see it.
+	Sync() error
+}
+
+// Name turns a URL or request path such as "/docs/a.txt" into the FS name
+// "docs/a.txt". It never climbs above the top directory.
+func Name(p string) string {
+	p = path.Clean("/" + filepath.ToSlash(p))
+	if p == "/" {
+		return "."
+	}
+	return p[1:]
+}
+
+// Local is an FS backed by a directory on disk.
+type Local struct {
+	root string
+}
+
+// NewLocal returns an FS for the directory root.
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// Root is the directory on disk that holds the files.
+func (l *Local) Root() string { return l.root }
+
+func (l *Local) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) || runtime.GOOS == "windows" && strings.ContainsAny(name, `\:`) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(l.root, filepath.FromSlash(name)), nil
+}
+
+func (l *Local) Open(name string) (fs.File, error) {
+	p, err := l.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (l *Local) Stat(name string) (fs.FileInfo, error) {
+	p, err := l.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (l *Local) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := l.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (l *Local) OpenWrite(name string) (File, error) {
+	p, err := l.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+func (l *Local) MkdirAll(name string) error {
+	p, err := l.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, 0755)
+}
+
+func (l *Local) Rename(oldname, newname string) error {
+	oldPath, err := l.path("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newPath, err := l.path("rename", newname)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (l *Local) Remove(name string) error {
+	p, err := l.path("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
@@ -0,0 +1,95 @@
+// Keeps a directory listing current using the /events stream.
+const livePath = document.currentScript.dataset.path;
+
+let uploads = [];
+let refreshTimer = null;
+
+function formatSize(bytes) {
+  if (bytes === 0) return '0 B';
+  const k = 1024;
+  const sizes = ['B', 'KB', 'MB', 'GB', 'TB'];
+  const i = Math.floor(Math.log(bytes) / Math.log(k));
+  return parseFloat((bytes / Math.pow(k, i)).toFixed(2)) + ' ' + sizes[i];
+}
+
+// Refetch this page and swap in the new listing, so sorting, filtering and
+// the grid/list view stay exactly as the server renders them.
+function scheduleRefresh() {
+  clearTimeout(refreshTimer);
+  refreshTimer = setTimeout(async () => {
+    try {
+      const res = await fetch(window.location.href);
+      if (!res.ok) return;
+      const doc = new DOMParser().parseFromString(await res.text(), 'text/html');
+      const fresh = doc.getElementById('files');
+      const current = document.getElementById('files');
+      if (fresh && current) {
+        current.replaceWith(fresh);
+        renderUploads();
+      }
+    } catch (err) {
+      console.warn('Refresh failed', err);
+    }
+  }, 300);
+}
+
+function progressText(u) {
+  if (!u.size) return 'Uploading… ' + formatSize(u.received);
+  return 'Uploading… ' + Math.floor(u.received * 100 / u.size) + '%';
+}
+
+function renderUploads() {
+  document.querySelectorAll('#files .placeholder').forEach(el => el.remove());
+  const grid = document.querySelector('#files .grid');
+  const tbody = document.querySelector('#files .list tbody');
+
+  for (const u of uploads) {
+    const pct = u.size ? Math.min(100, u.received * 100 / u.size) : 0;
+    if (grid) {
+      const card = document.createElement('div');
+      card.className = 'card placeholder';
+      const content = document.createElement('div');
+      content.className = 'card-content';
+      const icon = document.createElement('div');
+      icon.className = 'icon';
+      icon.textContent = '⏳';
+      const name = document.createElement('div');
+      name.className = 'name';
+      name.textContent = u.name;
+      const size = document.createElement('div');
+      size.className = 'size';
+      size.textContent = progressText(u);
+      const bar = document.createElement('div');
+      bar.className = 'bar';
+      bar.innerHTML = '<div></div>';
+      bar.firstChild.style.width = pct + '%';
+      content.append(icon, name, size, bar);
+      card.append(content);
+      grid.prepend(card);
+    } else if (tbody) {
+      const row = document.createElement('tr');
+      row.className = 'placeholder';
+      const name = document.createElement('td');
+      name.textContent = '⏳ ' + u.name;
+      const size = document.createElement('td');
+      size.className = 'num';
+      size.textContent = progressText(u);
+      row.append(name, size, document.createElement('td'), document.createElement('td'));
+      tbody.prepend(row);
+    }
+  }
+}
+
+if (window.EventSource) {
+  const source = new EventSource('/events?path=' + encodeURIComponent(livePath));
+  source.addEventListener('update', scheduleRefresh);
+  source.addEventListener('remove', scheduleRefresh);
+  source.addEventListener('reload', scheduleRefresh);
+  source.addEventListener('uploads', e => {
+    const next = JSON.parse(e.data);
+    // An upload that disappeared was finalized or abandoned.
+    if (uploads.some(u => !next.some(n => n.path === u.path))) scheduleRefresh();
+    uploads = next;
+    renderUploads();
+  });
+}
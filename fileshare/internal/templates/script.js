@@ -1,5 +1,6 @@
-const CHUNK_SIZE = 4 << 20
-const PARALLEL_CHUNKS = 4
+// The server passes its configured chunking on the script tag.
+const CHUNK_SIZE = Number(document.currentScript.dataset.chunkSize) || 4 << 20
+const PARALLEL_CHUNKS = Number(document.currentScript.dataset.parallelChunks) || 4
 
 const urlParams = new URLSearchParams(window.location.search);
 const targetDir = urlParams.get('dir') || "/";
@@ -77,6 +78,7 @@ async function uploadFiles() {
   for (let i = 0; i < files.length; i++) {
     totalSize += files[i].size;
   }
+  const skipped = [];
 
   for (let i = 0; i < files.length; i++) {
     const file = files[i];
@@ -113,6 +115,13 @@ async function uploadFiles() {
 
       totalUploaded += file.size;
     } catch (err) {
+      if (err.status === 403) {
+        // Hidden or ignored on the server, like .DS_Store; the rest of
+        // the folder still goes up.
+        skipped.push(relativePath);
+        totalUploaded += file.size;
+        continue;
+      }
       if (err.name === 'AbortError') {
         statusDisplay.innerText = "Upload cancelled.";
       } else {
@@ -125,9 +134,33 @@ async function uploadFiles() {
   }
 
   statusDisplay.innerText = "Done! Redirecting...";
+  if (skipped.length > 0) {
+    statusDisplay.innerText = "Done, but the server refused " + skipped.join(", ") + ". Redirecting...";
+  }
   setTimeout(() => {
     window.location.href = targetDir;
-  }, 1000);
+  }, skipped.length > 0 ? 4000 : 1000);
+}
+
+// uploadError turns a failed response into an Error carrying its status.
+async function uploadError(response) {
+  const err = new Error(await response.text());
+  err.status = response.status;
+  return err;
+}
+
+// fetch that waits and retries while the server answers 429/503 with Retry-After
+async function fetchWithRetry(url, options) {
+  for (;;) {
+    const response = await fetch(url, options);
+    if (response.status !== 429 && response.status !== 503) return response;
+    const retryAfter = parseInt(response.headers.get('Retry-After'), 10);
+    const waitMs = (isNaN(retryAfter) ? 2 : retryAfter) * 1000;
+    await new Promise((resolve) => setTimeout(resolve, waitMs));
+    if (options.signal && options.signal.aborted) {
+      throw new DOMException('Upload cancelled', 'AbortError');
+    }
+  }
 }
 
 // Upload a single file in parallel chunks with per-chunk progress
@@ -135,6 +168,9 @@ async function uploadFileInChunks(file, onProgress) {
   const totalChunks = Math.ceil(file.size / CHUNK_SIZE);
   let uploadedBytes = 0;
   const relativePath = file.webkitRelativePath || file.name;
+  // Ties every chunk of this file together in the server logs
+  const uploadId = (crypto.randomUUID && crypto.randomUUID()) ||
+    (Date.now().toString(36) + Math.random().toString(36).slice(2));
 
   let nextChunk = 0;
   const activeUploads = new Set();
@@ -144,10 +180,12 @@ async function uploadFileInChunks(file, onProgress) {
     const end = Math.min(start + CHUNK_SIZE, file.size);
     const chunk = file.slice(start, end);
 
-    const promise = fetch("/upload?dir=" + encodeURIComponent(targetDir), {
+    const promise = fetchWithRetry("/upload?dir=" + encodeURIComponent(targetDir), {
       method: 'POST',
       headers: {
         'X-File-Name': relativePath,
+        'X-Upload-ID': uploadId,
+        'X-File-Size': String(file.size),
         'X-Chunk-Offset': String(start),
         'X-Final-Chunk': 'false',
         'Content-Type': 'application/octet-stream'
@@ -155,7 +193,7 @@ async function uploadFileInChunks(file, onProgress) {
       body: chunk,
       signal: uploadController.signal
     }).then(async (response) => {
-      if (!response.ok) throw new Error(await response.text());
+      if (!response.ok) throw await uploadError(response);
 
       uploadedBytes += (end - start);
       onProgress(uploadedBytes);
@@ -189,6 +227,7 @@ async function uploadFileInChunks(file, onProgress) {
     method: 'POST',
     headers: {
       'X-File-Name': relativePath,
+      'X-Upload-ID': uploadId,
       'X-Chunk-Offset': '0',
       'X-Final-Chunk': 'true',
       'Content-Type': 'application/octet-stream'
@@ -198,7 +237,7 @@ async function uploadFileInChunks(file, onProgress) {
   });
 
   if (!finalResponse.ok) {
-    throw new Error(await finalResponse.text());
+    throw await uploadError(finalResponse);
   }
 }
 function cancelUpload() {
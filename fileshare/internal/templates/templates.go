@@ -6,6 +6,9 @@ import _ "embed"
 //go:embed script.js
 var UploadScript []byte
 
+//go:embed live.js
+var LiveScript []byte
+
 const BrowseTpl = `
 <!DOCTYPE html>
 <html>
@@ -57,6 +60,24 @@ const BrowseTpl = `
             font-size: 14px;
         }
         .download-btn:hover { background: #eef}
+        .search { max-width: 600px; margin: 0 auto 20px; }
+        .search input { width: 100%; box-sizing: border-box; padding: 12px; border: 1px solid #ddd; border-radius: 8px; font-size: 16px; }
+        .toolbar { display: flex; flex-wrap: wrap; gap: 8px; justify-content: center; margin-bottom: 20px; }
+        .toolbar input, .toolbar select, .toolbar button { padding: 8px 10px; border: 1px solid #ddd; border-radius: 6px; background: white; font-size: 14px; }
+        .toolbar button.active { background: #007bff; color: white; border-color: #007bff; }
+        .list { width: 100%; border-collapse: collapse; background: white; border-radius: 8px; overflow: hidden; box-shadow: 0 2px 5px rgba(0,0,0,0.1); }
+        .list th, .list td { padding: 10px 12px; text-align: left; border-bottom: 1px solid #eee; font-size: 14px; }
+        .list th a, .list td a { color: #007bff; text-decoration: none; }
+        .list td a { word-break: break-word; }
+        .list .num { color: #666; white-space: nowrap; }
+        .hits { max-width: 900px; margin: 30px auto; }
+        .hit { background: white; border-radius: 8px; padding: 12px 15px; margin-bottom: 10px; box-shadow: 0 2px 5px rgba(0,0,0,0.1); }
+        .hit a { color: #007bff; font-weight: bold; text-decoration: none; word-break: break-all; }
+        .hit pre { margin: 6px 0 0; font-size: 13px; white-space: pre-wrap; word-break: break-word; color: #444; }
+        .hit .line { color: #999; }
+        .placeholder { opacity: 0.6; }
+        .placeholder .bar { height: 4px; background: #eee; margin-top: 8px; border-radius: 2px; overflow: hidden; }
+        .placeholder .bar div { height: 100%; background: #28a745; }
         .upload-btn { display: block; max-width: 300px; margin: 20px auto; padding: 15px; background: #007bff; color: white; text-align: center; border-radius: 8px; text-decoration: none; font-weight: bold;}
     </style>
 </head>
@@ -69,6 +90,48 @@ const BrowseTpl = `
         {{end}}
     </div>
     <a href="/upload?dir={{.CurrentPath}}" class="upload-btn">Upload New File</a>
+    <form action="/search" method="get" class="search">
+        <input type="search" name="q" value="{{.Query}}" placeholder="Search all files...">
+    </form>
+    <form method="get" class="toolbar">
+        {{if .Query}}<input type="hidden" name="q" value="{{.Query}}">{{end}}
+        <input type="search" name="filter" value="{{.Options.Filter}}" placeholder="Filter this list...">
+        <select name="sort" onchange="this.form.submit()">
+            <option value="name" {{if eq .Options.Sort "name"}}selected{{end}}>Name</option>
+            <option value="size" {{if eq .Options.Sort "size"}}selected{{end}}>Size</option>
+            <option value="mtime" {{if eq .Options.Sort "mtime"}}selected{{end}}>Modified</option>
+            <option value="type" {{if eq .Options.Sort "type"}}selected{{end}}>Type</option>
+        </select>
+        <select name="order" onchange="this.form.submit()">
+            <option value="asc" {{if eq .Options.Order "asc"}}selected{{end}}>Ascending</option>
+            <option value="desc" {{if eq .Options.Order "desc"}}selected{{end}}>Descending</option>
+        </select>
+        <button type="submit" name="view" value="grid" {{if eq .Options.View "grid"}}class="active"{{end}}>Grid</button>
+        <button type="submit" name="view" value="list" {{if eq .Options.View "list"}}class="active"{{end}}>List</button>
+    </form>
+    <div id="files">
+    {{if eq .Options.View "list"}}
+    <table class="list">
+        <thead>
+            <tr>
+                <th><a href="{{.Options.SortLink "name"}}">Name</a></th>
+                <th><a href="{{.Options.SortLink "size"}}">Size</a></th>
+                <th><a href="{{.Options.SortLink "mtime"}}">Modified</a></th>
+                <th></th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Files}}
+            <tr>
+                <td><a href="{{.Path}}">{{if .IsDir}}📁{{else}}📄{{end}} {{.Name}}</a></td>
+                <td class="num">{{.Size}}{{if .Files}} · {{.Files}} files{{end}}</td>
+                <td class="num">{{.Modified}}</td>
+                <td>{{if .IsDir}}<a href="{{.DownloadURL}}">Save</a>{{else if .DownloadURL}}<a href="{{.DownloadURL}}" download>Save</a>{{end}}</td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}
     <div class="grid">
         {{range .Files}}
         <div class="card">
@@ -77,16 +140,57 @@ const BrowseTpl = `
                     {{if .IsDir}} 📁 {{else}} 📄 {{end}}
                 </div>
                 <div class="name">{{.Name}}</div>
-                {{if not .IsDir}} <div class="size">{{.Size}}</div> {{end}}
+                <div class="size">{{.Size}}{{if .Files}} · {{.Files}} files{{end}}</div>
             </a>
             {{if .DownloadURL}}
             <div class="actions">
-                <a href="{{.DownloadURL}}" class="download-btn" download>Save</a>
+                <a href="{{.DownloadURL}}" class="download-btn" {{if not .IsDir}}download{{end}}>Save</a>
             </div>
             {{end}}
         </div>
         {{end}}
     </div>
+    {{end}}
+    </div>
+    {{if .Hits}}
+    <div class="hits">
+        <h2>Matches inside files</h2>
+        {{range .Hits}}
+        <div class="hit">
+            <a href="{{.Path}}">{{.Path}}</a>
+            {{range .Snippets}}<pre>{{.}}</pre>{{end}}
+        </div>
+        {{end}}
+    </div>
+    {{end}}
+    {{if .Live}}<script src="/static/live.js" data-path="{{.CurrentPath}}"></script>{{end}}
+</body>
+</html>
+`
+
+const QueuedTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta http-equiv="refresh" content="{{.Retry}};url={{.Refresh}}">
+    <title>Waiting to download</title>
+    <style>
+        body { font-family: -apple-system, system-ui, sans-serif; background: #f0f2f5; padding: 20px; display: flex; justify-content: center; align-items: center; min-height: 100vh; margin: 0; }
+        .container { background: white; padding: 30px; border-radius: 12px; box-shadow: 0 4px 12px rgba(0,0,0,0.1); width: 100%; max-width: 400px; text-align: center; }
+        h1 { margin-top: 0; color: #333; }
+        .position { font-size: 48px; font-weight: bold; color: #007bff; margin: 10px 0; }
+        p { color: #555; word-break: break-word; }
+        .back-link { display: block; margin-top: 15px; color: #666; text-decoration: none; font-size: 14px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Preparing {{.Path}}</h1>
+        {{if .Position}}<div class="position">#{{.Position}}</div>{{end}}
+        <p>The server is busy with other downloads. This page checks again every few seconds and your zip will start automatically.</p>
+        <a class="back-link" href="javascript:history.back()">Go back</a>
+    </div>
 </body>
 </html>
 `
@@ -173,7 +277,306 @@ const UploadTpl = `
         <div id="status"></div>
         <button type="button" class="cancel-btn" onclick="cancelUpload()">Cancel / Go Back</button>
     </div>
-		<script src="/static/upload.js"></script>
+		<script src="/static/upload.js" data-chunk-size="{{.ChunkSize}}" data-parallel-chunks="{{.ParallelChunks}}"></script>
+</body>
+</html>
+`
+
+const AuditTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Audit Log</title>
+    <style>
+        body { font-family: -apple-system, system-ui, sans-serif; background: #f4f4f4; padding: 20px; }
+        h1 { text-align: center; color: #333; }
+        .status { max-width: 600px; margin: 0 auto 20px; padding: 12px; border-radius: 8px; text-align: center; font-weight: bold; }
+        .ok { background: #d4edda; color: #155724; }
+        .bad { background: #f8d7da; color: #721c24; }
+        table { width: 100%; border-collapse: collapse; background: white; border-radius: 8px; overflow: hidden; box-shadow: 0 2px 5px rgba(0,0,0,0.1); }
+        th, td { padding: 8px 10px; text-align: left; border-bottom: 1px solid #eee; font-size: 13px; }
+        td.path { word-break: break-all; }
+        td.hash { font-family: monospace; color: #888; }
+    </style>
+</head>
+<body>
+    <h1>Audit Log</h1>
+    {{if .Error}}
+    <div class="status bad">Chain broken after {{.Verified}} records: {{.Error}}</div>
+    {{else}}
+    <div class="status ok">Chain verified: {{.Verified}} records</div>
+    {{end}}
+    <table>
+        <thead>
+            <tr><th>#</th><th>Time</th><th>Client</th><th>Identity</th><th>Action</th><th>Path</th><th>Bytes</th><th>SHA-256</th></tr>
+        </thead>
+        <tbody>
+            {{range .Records}}
+            <tr>
+                <td>{{.Seq}}</td>
+                <td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+                <td>{{.Client}}</td>
+                <td>{{.Identity}}</td>
+                <td>{{.Action}}</td>
+                <td class="path">{{.Path}}</td>
+                <td>{{.Bytes}}</td>
+                <td class="hash">{{.SHA256}}</td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+</body>
+</html>
+`
+
+const AdminTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Admin</title>
+    <style>
+        body { font-family: -apple-system, system-ui, sans-serif; background: #f4f4f4; padding: 20px; }
+        h1 { text-align: center; color: #333; }
+        h2 { color: #333; font-size: 18px; margin-top: 30px; }
+        .status { max-width: 600px; margin: 0 auto 20px; padding: 12px; border-radius: 8px; text-align: center; font-weight: bold; background: #d4edda; color: #155724; }
+        .summary { display: grid; grid-template-columns: repeat(auto-fill, minmax(200px, 1fr)); gap: 15px; }
+        .tile { background: white; border-radius: 12px; padding: 15px; box-shadow: 0 2px 5px rgba(0,0,0,0.1); }
+        .tile .label { font-size: 12px; color: #888; }
+        .tile .value { font-size: 20px; font-weight: bold; color: #333; word-break: break-all; }
+        .bar { height: 8px; background: #eee; border-radius: 4px; overflow: hidden; margin-top: 8px; }
+        .bar div { height: 100%; background: #007bff; }
+        table { width: 100%; border-collapse: collapse; background: white; border-radius: 8px; overflow: hidden; box-shadow: 0 2px 5px rgba(0,0,0,0.1); }
+        th, td { padding: 8px 10px; text-align: left; border-bottom: 1px solid #eee; font-size: 13px; }
+        td.path { word-break: break-all; }
+        .empty { color: #888; font-size: 13px; }
+        form { display: inline; }
+        button { padding: 4px 10px; border: 1px solid #ccc; border-radius: 6px; background: white; cursor: pointer; }
+        button.danger { color: #c00; border-color: #e99; }
+        .limits { background: white; border-radius: 8px; padding: 10px 15px; box-shadow: 0 2px 5px rgba(0,0,0,0.1); display: block; }
+        .limits label { margin-right: 15px; font-size: 13px; white-space: nowrap; }
+    </style>
+</head>
+<body>
+    <h1>Admin</h1>
+    {{if .Message}}<div class="status">{{.Message}}</div>{{end}}
+
+    <div class="summary">
+        <div class="tile"><div class="label">Uptime</div><div class="value">{{.Uptime}}</div></div>
+        <div class="tile"><div class="label">Sharing</div><div class="value">{{.Root}}</div></div>
+        {{with .Disk}}
+        <div class="tile">
+            <div class="label">Disk</div>
+            <div class="value">{{.Free}} free</div>
+            <div class="label">{{.Used}} of {{.Total}} used</div>
+            <div class="bar"><div style="width: {{.Percent}}%"></div></div>
+        </div>
+        {{end}}
+        {{range .Pools}}
+        <div class="tile">
+            <div class="label">{{.Name}} pool</div>
+            <div class="value">{{.Busy}}/{{.Workers}} busy</div>
+            <div class="label">{{.Queued}} of {{.Capacity}} queued</div>
+            <div class="bar"><div style="width: {{.Percent}}%"></div></div>
+        </div>
+        {{end}}
+        <div class="tile">
+            <div class="label">Uploads</div>
+            <div class="value">{{if .UploadsPaused}}Paused{{else}}Running{{end}}</div>
+            <form method="POST" action="/admin/pause">
+                <input type="hidden" name="paused" value="{{if .UploadsPaused}}0{{else}}1{{end}}">
+                <button type="submit">{{if .UploadsPaused}}Resume{{else}}Pause{{end}}</button>
+            </form>
+        </div>
+    </div>
+
+    {{with .Bandwidth}}
+    <h2>Bandwidth limits</h2>
+    <form method="POST" action="/admin/limits" class="limits">
+        <p class="empty">Bytes per second, e.g. 500K or 10M. Leave empty for unlimited.</p>
+        <label>Total download <input name="down" value="{{.Down}}" size="6"></label>
+        <label>Total upload <input name="up" value="{{.Up}}" size="6"></label>
+        <label>Per client download <input name="clientDown" value="{{.ClientDown}}" size="6"></label>
+        <label>Per client upload <input name="clientUp" value="{{.ClientUp}}" size="6"></label>
+        <label><input type="checkbox" name="enabled" value="1" {{if .Enabled}}checked{{end}}> Enforce</label>
+        <button type="submit">Apply</button>
+    </form>
+    {{end}}
+
+    <h2>Active sessions</h2>
+    {{if .Sessions}}
+    <table>
+        <thead><tr><th>Client</th><th>Active</th><th>Requests</th><th>In</th><th>Out</th><th>Idle</th><th></th></tr></thead>
+        <tbody>
+            {{range .Sessions}}
+            <tr>
+                <td>{{.Addr}}{{if .Kicked}} (disconnected){{end}}</td>
+                <td>{{.Active}}</td>
+                <td>{{.Requests}}</td>
+                <td>{{.In}}</td>
+                <td>{{.Out}}</td>
+                <td>{{.Idle}}</td>
+                <td><form method="POST" action="/admin/kick"><input type="hidden" name="client" value="{{.Addr}}"><button class="danger" type="submit">Disconnect</button></form></td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}<p class="empty">No clients in the last few minutes.</p>{{end}}
+
+    <h2>Transfers in progress</h2>
+    {{if .Transfers}}
+    <table>
+        <thead><tr><th>Client</th><th>Request</th><th>In</th><th>Out</th><th>Running</th><th></th></tr></thead>
+        <tbody>
+            {{range .Transfers}}
+            <tr>
+                <td>{{.Client}}</td>
+                <td class="path">{{.Method}} {{.Path}}{{if .Query}}?{{.Query}}{{end}}</td>
+                <td>{{.In}}</td>
+                <td>{{.Out}}</td>
+                <td>{{.Elapsed}}</td>
+                <td><form method="POST" action="/admin/cancel"><input type="hidden" name="id" value="{{.ID}}"><button class="danger" type="submit">Cancel</button></form></td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}<p class="empty">Nothing is transferring right now.</p>{{end}}
+
+    <h2>Uploads</h2>
+    {{if .Uploads}}
+    <table>
+        <thead><tr><th>File</th><th>Client</th><th>Progress</th><th>Started</th></tr></thead>
+        <tbody>
+            {{range .Uploads}}
+            <tr>
+                <td class="path">{{.Path}}</td>
+                <td>{{.Client}}</td>
+                <td>{{.Progress}}</td>
+                <td>{{.Started.Format "15:04:05"}}</td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}<p class="empty">No uploads in progress.</p>{{end}}
+
+    <h2>Partial uploads</h2>
+    <p class="empty">Partials older than {{.PartialMaxAge}} are removed automatically. Listed by the last sweep, {{.PartialsAge}} ago.
+        <form method="POST" action="/admin/cleanup"><button type="submit">Clean up stale now</button></form>
+        <form method="POST" action="/admin/cleanup"><input type="hidden" name="all" value="1"><button class="danger" type="submit">Remove all idle</button></form>
+    </p>
+    {{if .Partials}}
+    <table>
+        <thead><tr><th>File</th><th>Size</th><th>Age</th></tr></thead>
+        <tbody>
+            {{range .Partials}}
+            <tr><td class="path">{{.Path}}</td><td>{{.SizeText}}</td><td>{{.Age}}</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}<p class="empty">No partial uploads on disk.</p>{{end}}
+
+    <h2>Share links</h2>
+    <form method="POST" action="/admin/share" class="limits">
+        <p class="empty">{{if .LinksOnly}}Files are only served to visitors with a link, within the folder it was made for.{{else}}Anyone on the network can browse; a link opens a folder directly.{{end}} Links end when the server stops.</p>
+        <label>Folder or file <input name="path" value="/" size="20"></label>
+        <label>Expires after <input name="ttl" placeholder="never" size="6"></label>
+        <button type="submit">Create link</button>
+    </form>
+    {{if .ShareLinks}}
+    <table>
+        <thead><tr><th>Path</th><th>Link</th><th>Created</th><th>Expires</th><th>Visits</th><th></th></tr></thead>
+        <tbody>
+            {{range .ShareLinks}}
+            <tr>
+                <td class="path">{{.Path}}</td>
+                <td class="path"><a href="{{.URL}}">{{.URL}}</a></td>
+                <td>{{.Created.Format "15:04:05"}}</td>
+                <td>{{.ExpiresText}}</td>
+                <td>{{.Visits}}</td>
+                <td><form method="POST" action="/admin/revoke"><input type="hidden" name="token" value="{{.Token}}"><button class="danger" type="submit">Revoke</button></form></td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}<p class="empty">No share links.</p>{{end}}
+
+    <h2>Recent warnings and errors</h2>
+    {{if .Errors}}
+    <table>
+        <thead><tr><th>Time</th><th>Level</th><th>Message</th><th>Details</th></tr></thead>
+        <tbody>
+            {{range .Errors}}
+            <tr><td>{{.Time.Format "15:04:05"}}</td><td>{{.Level}}</td><td>{{.Message}}</td><td class="path">{{.Attrs}}</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}<p class="empty">No warnings since startup.</p>{{end}}
+</body>
+</html>
+`
+
+const PushTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta http-equiv="refresh" content="3;url=/push">
+    <title>Incoming files</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f4f6f8; margin: 0; padding: 20px; color: #333; }
+        .container { max-width: 720px; margin: 0 auto; }
+        h1 { font-size: 22px; }
+        .offer { background: white; border-radius: 8px; box-shadow: 0 1px 3px rgba(0,0,0,0.1); padding: 16px; margin-bottom: 12px; }
+        .offer h2 { font-size: 16px; margin: 0 0 8px; }
+        .offer ul { margin: 0 0 12px; padding-left: 20px; color: #555; font-size: 14px; max-height: 160px; overflow-y: auto; }
+        .actions form { display: inline; }
+        .btn { border: none; border-radius: 4px; padding: 8px 16px; font-size: 14px; cursor: pointer; color: white; }
+        .accept { background: #28a745; }
+        .decline { background: #dc3545; }
+        .msg { background: #e7f3ff; border-radius: 4px; padding: 10px; margin-bottom: 12px; }
+        .empty { color: #888; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Incoming files</h1>
+        {{if .Message}}<div class="msg">{{.Message}}</div>{{end}}
+        {{range .Offers}}
+        <div class="offer">
+            <h2>{{.From}} ({{.Client}}) wants to send {{len .Files}} file(s), {{.Size}}</h2>
+            <ul>{{range .Files}}<li>{{.Name}}</li>{{end}}</ul>
+            <div class="actions">
+                <form method="post" action="/push/answer"><input type="hidden" name="id" value="{{.ID}}"><input type="hidden" name="accept" value="1"><button class="btn accept">Accept</button></form>
+                <form method="post" action="/push/answer"><input type="hidden" name="id" value="{{.ID}}"><button class="btn decline">Decline</button></form>
+                <span class="empty">waiting {{.Age}}</span>
+            </div>
+        </div>
+        {{else}}
+        <p class="empty">Nothing waiting. Offers from "fileshare push" appear here.</p>
+        {{end}}
+    </div>
+</body>
+</html>
+`
+
+const QRTpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Scan to open FileShare</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: white; margin: 0; min-height: 100vh; display: flex; flex-direction: column; align-items: center; justify-content: center; color: #333; }
+        img { width: min(80vw, 80vh); height: min(80vw, 80vh); image-rendering: pixelated; }
+        p { font-size: 20px; word-break: break-all; text-align: center; padding: 0 20px; }
+    </style>
+</head>
+<body>
+    <img src="/qr?format=svg" alt="QR code for {{.URL}}">
+    <p>{{.URL}}</p>
 </body>
 </html>
 `
@@ -2,67 +2,413 @@
 package worker
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Job is a unit of work. Process should return promptly once ctx is done;
+// ctx is cancelled when the submitter goes away or the job times out.
 type Job interface {
-	Process()
+	Process(ctx context.Context) error
 }
 
+// JobFunc adapts a function to the Job interface.
+type JobFunc func(ctx context.Context) error
+
+func (f JobFunc) Process(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Options describe how a job is scheduled.
+type Options struct {
+	// Client identifies who the job is for; clients take turns.
+	Client   string
+	Priority Priority
+	// Timeout bounds how long the job may run once started. Zero falls back
+	// to the pool's JobTimeout.
+	Timeout time.Duration
+}
+
+// PanicError is returned for a job that panicked. The worker survives.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("worker: job panicked: %v", e.Value)
+}
+
+// Priority orders jobs within the queue. Higher priorities run first; jobs
+// of equal priority are shared out fairly between clients.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+var (
+	ErrQueueFull = errors.New("worker: queue is full")
+	ErrStopped   = errors.New("worker: pool is stopped")
+	ErrCancelled = errors.New("worker: job cancelled before it started")
+)
+
+type ticketState int
+
+const (
+	stateQueued ticketState = iota
+	stateRunning
+	stateCancelled
+)
+
+// Ticket tracks one submitted job.
+type Ticket struct {
+	pool     *Pool
+	client   *clientQueue
+	priority Priority
+	timeout  time.Duration
+	seq      uint64
+	job      Job
+	ctx      context.Context
+	stop     func() bool
+	state    ticketState
+	started  chan struct{}
+	done     chan struct{}
+	err      error
+}
+
+// Started is closed when a worker picks the job up.
+func (t *Ticket) Started() <-chan struct{} {
+	return t.started
+}
+
+// Done is closed when the job has finished or was cancelled before it
+// started.
+func (t *Ticket) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the job's result once Done is closed: the error from Process,
+// a *PanicError, or the reason the job never ran.
+func (t *Ticket) Err() error {
+	<-t.done
+	return t.err
+}
+
+// Position estimates how many jobs will start before this one, plus one. It
+// is 0 once the job has started or was cancelled. Per-client limits are not
+// taken into account, so the real wait can be longer.
+func (t *Ticket) Position() int {
+	p := t.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state != stateQueued {
+		return 0
+	}
+
+	heads := make(map[*clientQueue]int, len(p.order))
+	cursor := p.cursor
+	for pos := 1; ; pos++ {
+		i := p.choose(cursor, func(cq *clientQueue) *Ticket {
+			if heads[cq] < len(cq.tickets) {
+				return cq.tickets[heads[cq]]
+			}
+			return nil
+		})
+		if i < 0 {
+			return 0
+		}
+		cq := p.order[i]
+		if cq.tickets[heads[cq]] == t {
+			return pos
+		}
+		heads[cq]++
+		cursor = i + 1
+	}
+}
+
+// Cancel removes the job from the queue. It returns false if the job has
+// already started; cancel the submitter's context to stop a running job.
+func (t *Ticket) Cancel() bool {
+	return t.cancel(ErrCancelled)
+}
+
+func (t *Ticket) cancel(reason error) bool {
+	p := t.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state != stateQueued {
+		return t.state == stateCancelled
+	}
+	t.state = stateCancelled
+	t.err = reason
+	t.stop()
+	close(t.done)
+	cq := t.client
+	for i, other := range cq.tickets {
+		if other == t {
+			cq.tickets = append(cq.tickets[:i], cq.tickets[i+1:]...)
+			break
+		}
+	}
+	p.queued--
+	p.tidy(cq)
+	return true
+}
+
+// clientQueue holds the waiting jobs of one client, highest priority first.
+type clientQueue struct {
+	key     string
+	running int
+	tickets []*Ticket
+}
+
+// Pool runs jobs on a fixed number of workers. Instead of one FIFO queue it
+// keeps a queue per client and takes turns between them, so one client
+// submitting many jobs cannot starve the others.
 type Pool struct {
-	JobQueue chan Job
 	WorkerCount int
-	wg sync.WaitGroup
+	// MaxPerClient caps how many jobs of one client run at once; 0 means no
+	// limit. Set it before Start, or with SetMaxPerClient afterwards.
+	MaxPerClient int
+	// JobTimeout bounds jobs submitted without their own Timeout; 0 means
+	// no limit. Set it before Start, or with SetJobTimeout afterwards.
+	JobTimeout time.Duration
+
+	capacity int
+	wg       sync.WaitGroup
+	active   atomic.Int32
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	clients map[string]*clientQueue
+	order   []*clientQueue // clients with waiting jobs, in round-robin order
+	cursor  int
+	queued  int
+	seq     uint64
+	stopped bool
 }
 
 func NewPool(workerCount int, queueSize int) *Pool {
-	return &Pool {
-		JobQueue: make(chan Job, queueSize),
+	p := &Pool{
 		WorkerCount: workerCount,
+		capacity:    queueSize,
+		clients:     map[string]*clientQueue{},
 	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
 }
 
 func (p *Pool) Start() {
 	for i := 0; i < p.WorkerCount; i++ {
 		p.wg.Add(1)
-		go func(workerID int) {
+		go func() {
 			defer p.wg.Done()
-			for job := range p.JobQueue {
-				job.Process()
+			for {
+				t := p.take()
+				if t == nil {
+					return
+				}
+				p.active.Add(1)
+				p.run(t)
+				p.active.Add(-1)
+				p.finish(t)
 			}
-		}(i)
+		}()
 	}
 }
 
-func (p *Pool) TrySubmit(ctx context.Context, job Job) bool {
-	select {
-	case p.JobQueue <- job:
-		return true
-	case <- ctx.Done():
-		return false
+// Enqueue queues job without blocking. It fails with ErrQueueFull when the
+// pool already holds its capacity of waiting jobs. The job runs with ctx, so
+// cancelling ctx withdraws a queued job and stops a running one.
+func (p *Pool) Enqueue(ctx context.Context, job Job, opts Options) (*Ticket, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return nil, ErrStopped
+	}
+	if p.queued >= p.capacity {
+		return nil, ErrQueueFull
+	}
+
+	cq := p.clients[opts.Client]
+	if cq == nil {
+		cq = &clientQueue{key: opts.Client}
+		p.clients[opts.Client] = cq
+	}
+	if len(cq.tickets) == 0 {
+		p.order = append(p.order, cq)
+	}
+	p.seq++
+	t := &Ticket{
+		pool:     p,
+		client:   cq,
+		priority: opts.Priority,
+		timeout:  cmp.Or(opts.Timeout, p.JobTimeout),
+		seq:      p.seq,
+		job:      job,
+		ctx:      ctx,
+		started:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	t.stop = context.AfterFunc(ctx, func() { t.cancel(context.Cause(ctx)) })
+	i := len(cq.tickets)
+	for i > 0 && cq.tickets[i-1].priority < t.priority {
+		i--
+	}
+	cq.tickets = append(cq.tickets, nil)
+	copy(cq.tickets[i+1:], cq.tickets[i:])
+	cq.tickets[i] = t
+	p.queued++
+	p.cond.Signal()
+	return t, nil
+}
+
+// take blocks until a job may run, or returns nil once the pool is stopped
+// and drained.
+func (p *Pool) take() *Ticket {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		i := p.choose(p.cursor, func(cq *clientQueue) *Ticket {
+			if len(cq.tickets) == 0 || (p.MaxPerClient > 0 && cq.running >= p.MaxPerClient) {
+				return nil
+			}
+			return cq.tickets[0]
+		})
+		if i >= 0 {
+			cq := p.order[i]
+			t := cq.tickets[0]
+			cq.tickets = cq.tickets[1:]
+			cq.running++
+			p.queued--
+			t.state = stateRunning
+			t.stop()
+			close(t.started)
+			p.cursor = i + 1
+			p.tidy(cq)
+			return t
+		}
+		if p.stopped && p.queued == 0 {
+			return nil
+		}
+		p.cond.Wait()
 	}
 }
 
-func (p *Pool) SubmitWithTimeout(job Job, timeout time.Duration) bool {
-	select {
-	case p.JobQueue <- job:
-		return true
-	case <- time.After(timeout):
-		return false
+// run executes one job, turning a panic into an error so the worker keeps
+// going.
+func (p *Pool) run(t *Ticket) {
+	ctx := t.ctx
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
 	}
+	defer func() {
+		if v := recover(); v != nil {
+			pe := &PanicError{Value: v, Stack: debug.Stack()}
+			t.err = pe
+			slog.Error("Worker job panicked", "panic", v, "stack", string(pe.Stack))
+		}
+		close(t.done)
+	}()
+	t.err = t.job.Process(ctx)
+}
+
+func (p *Pool) finish(t *Ticket) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t.client.running--
+	p.tidy(t.client)
+	// A job of this client may have been held back by MaxPerClient.
+	p.cond.Broadcast()
+}
+
+// choose returns the index in order of the client whose head job should run
+// next: the highest priority wins, ties go to the first client at or after
+// cursor. head returns a client's candidate job or nil. Caller must hold mu.
+func (p *Pool) choose(cursor int, head func(*clientQueue) *Ticket) int {
+	best, bestPrio := -1, Priority(0)
+	n := len(p.order)
+	for k := 0; k < n; k++ {
+		i := (cursor + k) % n
+		t := head(p.order[i])
+		if t != nil && (best < 0 || t.priority > bestPrio) {
+			best, bestPrio = i, t.priority
+		}
+	}
+	return best
+}
+
+// tidy drops a client from the round-robin order once it has nothing
+// waiting, and forgets it entirely once nothing is running either. Caller
+// must hold mu.
+func (p *Pool) tidy(cq *clientQueue) {
+	if len(cq.tickets) == 0 {
+		for i, other := range p.order {
+			if other == cq {
+				p.order = append(p.order[:i], p.order[i+1:]...)
+				if p.cursor > i {
+					p.cursor--
+				}
+				break
+			}
+		}
+		if p.cursor >= len(p.order) {
+			p.cursor = 0
+		}
+	}
+	if len(cq.tickets) == 0 && cq.running == 0 && p.clients[cq.key] == cq {
+		delete(p.clients, cq.key)
+	}
+}
+
+// SetMaxPerClient changes MaxPerClient on a running pool.
+func (p *Pool) SetMaxPerClient(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.MaxPerClient = n
+	p.cond.Broadcast()
+}
+
+// SetJobTimeout changes JobTimeout on a running pool. Jobs already queued
+// keep the timeout they were submitted with.
+func (p *Pool) SetJobTimeout(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.JobTimeout = d
+}
+
+// Active returns the number of workers currently running a job.
+func (p *Pool) Active() int {
+	return int(p.active.Load())
 }
 
 func (p *Pool) QueueLength() int {
-	return len(p.JobQueue)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queued
 }
 
 func (p *Pool) QueueCapacity() int {
-	return cap(p.JobQueue)
+	return p.capacity
 }
 
+// Stop refuses new jobs and waits for the queued ones to finish.
 func (p *Pool) Stop() {
-	close(p.JobQueue)
+	p.mu.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
 	p.wg.Wait()
 }
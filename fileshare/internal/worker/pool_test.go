@@ -0,0 +1,301 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// submission is one job queued behind a blocked pool.
+type submission struct {
+	name     string
+	client   string
+	priority Priority
+}
+
+// blockedPool returns a started pool of one worker that is busy until the
+// returned function is called.
+func blockedPool(t *testing.T, queue int) (*Pool, func()) {
+	t.Helper()
+	p := NewPool(1, queue)
+	p.Start()
+	release := make(chan struct{})
+	busy, err := p.Enqueue(context.Background(), JobFunc(func(ctx context.Context) error {
+		<-release
+		return nil
+	}), Options{Client: "busy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-busy.Started()
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(func() {
+		unblock()
+		p.Stop()
+	})
+	return p, unblock
+}
+
+func TestPoolOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []submission
+		want []string
+	}{
+		{
+			name: "clients take turns",
+			jobs: []submission{{"a1", "a", 0}, {"a2", "a", 0}, {"a3", "a", 0}, {"b1", "b", 0}, {"c1", "c", 0}},
+			want: []string{"a1", "b1", "c1", "a2", "a3"},
+		},
+		{
+			name: "higher priority first",
+			jobs: []submission{{"low", "a", PriorityLow}, {"normal", "b", PriorityNormal}, {"high", "c", PriorityHigh}},
+			want: []string{"high", "normal", "low"},
+		},
+		{
+			name: "priority within one client",
+			jobs: []submission{{"a1", "a", 0}, {"a2", "a", PriorityHigh}, {"a3", "a", 0}},
+			want: []string{"a2", "a1", "a3"},
+		},
+		{
+			name: "equal priorities share fairly",
+			jobs: []submission{{"a1", "a", PriorityHigh}, {"a2", "a", PriorityHigh}, {"b1", "b", PriorityHigh}, {"c1", "c", 0}},
+			want: []string{"a1", "b1", "a2", "c1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, unblock := blockedPool(t, 10)
+			var mu sync.Mutex
+			var order []string
+			var tickets []*Ticket
+			for _, s := range tt.jobs {
+				ticket, err := p.Enqueue(context.Background(), JobFunc(func(ctx context.Context) error {
+					mu.Lock()
+					order = append(order, s.name)
+					mu.Unlock()
+					return nil
+				}), Options{Client: s.client, Priority: s.priority})
+				if err != nil {
+					t.Fatal(err)
+				}
+				tickets = append(tickets, ticket)
+			}
+
+			// Position predicts the order before anything runs.
+			positions := make([]string, len(tickets))
+			for i, ticket := range tickets {
+				positions[ticket.Position()-1] = tt.jobs[i].name
+			}
+			if !slices.Equal(positions, tt.want) {
+				t.Errorf("positions predict %q, want %q", positions, tt.want)
+			}
+
+			unblock()
+			for _, ticket := range tickets {
+				if err := ticket.Err(); err != nil {
+					t.Fatal(err)
+				}
+				if ticket.Position() != 0 {
+					t.Errorf("finished job has position %d", ticket.Position())
+				}
+			}
+			if !slices.Equal(order, tt.want) {
+				t.Errorf("ran %q, want %q", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolMaxPerClient(t *testing.T) {
+	p := NewPool(3, 10)
+	p.MaxPerClient = 2
+	p.Start()
+	defer p.Stop()
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	release := make(chan struct{})
+	var tickets []*Ticket
+	for range 5 {
+		ticket, err := p.Enqueue(context.Background(), JobFunc(func(ctx context.Context) error {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+			<-release
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		}), Options{Client: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tickets = append(tickets, ticket)
+	}
+	<-tickets[1].Started()
+	// The third worker is idle but must not pick up a third job of a.
+	select {
+	case <-tickets[2].Started():
+		t.Fatal("third job of one client started")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Another client gets the free worker straight away.
+	other, err := p.Enqueue(context.Background(), JobFunc(func(ctx context.Context) error { return nil }), Options{Client: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	for _, ticket := range tickets {
+		ticket.Err()
+	}
+	if peak != 2 {
+		t.Errorf("%d jobs of one client ran at once, want 2", peak)
+	}
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	p, _ := blockedPool(t, 1)
+	job := JobFunc(func(ctx context.Context) error { return nil })
+	if _, err := p.Enqueue(context.Background(), job, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Enqueue(context.Background(), job, Options{}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Enqueue on a full queue = %v, want ErrQueueFull", err)
+	}
+	if p.QueueLength() != 1 {
+		t.Errorf("QueueLength = %d, want 1", p.QueueLength())
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	p, unblock := blockedPool(t, 10)
+	ran := make(chan string, 3)
+	enqueue := func(ctx context.Context, name string) *Ticket {
+		ticket, err := p.Enqueue(ctx, JobFunc(func(ctx context.Context) error {
+			ran <- name
+			return nil
+		}), Options{Client: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ticket
+	}
+	cancelled := enqueue(context.Background(), "cancelled")
+	ctx, cancel := context.WithCancel(context.Background())
+	withdrawn := enqueue(ctx, "withdrawn")
+	kept := enqueue(context.Background(), "kept")
+
+	if !cancelled.Cancel() {
+		t.Fatal("Cancel of a queued job failed")
+	}
+	cancel()
+	if err := cancelled.Err(); !errors.Is(err, ErrCancelled) {
+		t.Errorf("cancelled job: Err = %v, want ErrCancelled", err)
+	}
+	if err := withdrawn.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("withdrawn job: Err = %v, want context.Canceled", err)
+	}
+	if kept.Position() != 1 {
+		t.Errorf("remaining job at position %d, want 1", kept.Position())
+	}
+
+	unblock()
+	if err := kept.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if kept.Cancel() {
+		t.Error("Cancel of a finished job succeeded")
+	}
+	if got := <-ran; got != "kept" || len(ran) != 0 {
+		t.Errorf("ran %q and %d more, want only kept", got, len(ran))
+	}
+}
+
+func TestPoolJobErrors(t *testing.T) {
+	p := NewPool(1, 10)
+	p.JobTimeout = time.Hour
+	p.Start()
+	defer p.Stop()
+
+	tests := []struct {
+		name string
+		job  JobFunc
+		opts Options
+		want func(error) bool
+	}{
+		{
+			name: "error returned",
+			job:  func(ctx context.Context) error { return ErrStopped },
+			want: func(err error) bool { return errors.Is(err, ErrStopped) },
+		},
+		{
+			name: "own timeout beats the pool's",
+			job:  func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() },
+			opts: Options{Timeout: 10 * time.Millisecond},
+			want: func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		},
+		{
+			name: "panic",
+			job:  func(ctx context.Context) error { panic("boom") },
+			want: func(err error) bool {
+				var pe *PanicError
+				return errors.As(err, &pe) && pe.Value == "boom" && len(pe.Stack) > 0
+			},
+		},
+		// The worker survives the panic.
+		{
+			name: "after panic",
+			job:  func(ctx context.Context) error { return nil },
+			want: func(err error) bool { return err == nil },
+		},
+	}
+	for _, tt := range tests {
+		ticket, err := p.Enqueue(context.Background(), tt.job, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ticket.Err(); !tt.want(err) {
+			t.Errorf("%s: Err = %v", tt.name, err)
+		}
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	p, unblock := blockedPool(t, 10)
+	queued, err := p.Enqueue(context.Background(), JobFunc(func(ctx context.Context) error { return nil }), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	// Stop refuses new work but drains what is queued.
+	for {
+		_, err := p.Enqueue(context.Background(), JobFunc(func(ctx context.Context) error { return nil }), Options{})
+		if errors.Is(err, ErrStopped) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	unblock()
+	<-stopped
+	select {
+	case <-queued.Done():
+	default:
+		t.Error("Stop returned before the queued job ran")
+	}
+}